@@ -13,6 +13,7 @@ import (
 	"firefly-importer/db"
 	"firefly-importer/firefly"
 	"firefly-importer/handlers"
+	"firefly-importer/parser"
 
 	"github.com/gorilla/csrf"
 )
@@ -20,16 +21,41 @@ import (
 // setupRouter configures the dependencies and routes
 func setupRouter(cfg *config.Config, dbConn *sql.DB) *http.ServeMux {
 	client := firefly.NewClient(cfg.FireflyURL, cfg.FireflyToken)
+	client.PageLimit = cfg.FireflyPageLimit
 	appHandler := handlers.NewAppHandler(client, cfg, dbConn)
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", appHandler.IndexHandler)
 	mux.HandleFunc("POST /upload", appHandler.UploadHandler)
 	mux.HandleFunc("POST /save", appHandler.SaveHandler)
+	mux.HandleFunc("POST /profiles", appHandler.ProfileHandler)
+	mux.HandleFunc("POST /prompts", appHandler.PromptHandler)
 
 	return mux
 }
 
+// loadPrompts saves the prompt templates of a directory to the database. A template whose file
+// changed since the last start is stored as a new version.
+func loadPrompts(dir string, dbConn *sql.DB) {
+	prompts, err := parser.LoadPromptDir(dir)
+	if err != nil {
+		log.Printf("Failed to load prompt templates (ignoring): %v", err)
+		return
+	}
+	if dbConn == nil {
+		log.Printf("Ignoring %d prompt template(s) in %s: prompt templates require a database connection", len(prompts), dir)
+		return
+	}
+	for _, p := range prompts {
+		version, err := db.SavePrompt(dbConn, p)
+		if err != nil {
+			log.Printf("Failed to save prompt template %q (ignoring): %v", p.Name, err)
+			continue
+		}
+		log.Printf("Loaded prompt template %q version %d", p.Name, version)
+	}
+}
+
 // plaintextMiddleware marks every request as plaintext HTTP so that
 // gorilla/csrf skips the strict HTTPS-only Referer validation.
 func plaintextMiddleware(next http.Handler) http.Handler {
@@ -62,6 +88,10 @@ func main() {
 		defer dbConn.Close()
 	}
 
+	if cfg.PromptDir != "" {
+		loadPrompts(cfg.PromptDir, dbConn)
+	}
+
 	mux := setupRouter(cfg, dbConn)
 
 	// Derive a 32-byte CSRF key from config
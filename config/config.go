@@ -9,16 +9,23 @@ import (
 )
 
 type Config struct {
-	FireflyURL   string
-	FireflyToken string
-	VisionAPIURL string
-	VisionAPIKey string
-	VisionModel  string
-	Port         string
-	DatabaseURL  string
-	CSRFKey      string
-	Hostname     string
-	Debug        bool
+	FireflyURL         string
+	FireflyToken       string
+	FireflyPageLimit   int
+	VisionProvider     string
+	VisionAPIURL       string
+	VisionAPIKey       string
+	VisionModel        string
+	VisionMaxDimension int
+	VisionMaxBytes     int
+	PromptDir          string
+	DedupeDateWindow   int
+	DedupeSimilarity   float64
+	Port               string
+	DatabaseURL        string
+	CSRFKey            string
+	Hostname           string
+	Debug              bool
 }
 
 func LoadConfig() *Config {
@@ -28,18 +35,30 @@ func LoadConfig() *Config {
 	}
 
 	debugBool, _ := strconv.ParseBool(os.Getenv("DEBUG"))
+	fireflyPageLimit, _ := strconv.Atoi(os.Getenv("FIREFLY_PAGE_LIMIT"))
+	visionMaxDimension, _ := strconv.Atoi(os.Getenv("VISION_MAX_DIMENSION"))
+	visionMaxBytes, _ := strconv.Atoi(os.Getenv("VISION_MAX_BYTES"))
+	dedupeDateWindow, _ := strconv.Atoi(os.Getenv("DEDUPE_DATE_WINDOW"))
+	dedupeSimilarity, _ := strconv.ParseFloat(os.Getenv("DEDUPE_SIMILARITY"), 64)
 
 	config := &Config{
-		FireflyURL:   os.Getenv("FIREFLY_URL"),
-		FireflyToken: os.Getenv("FIREFLY_TOKEN"),
-		VisionAPIURL: os.Getenv("VISION_API_URL"),
-		VisionAPIKey: os.Getenv("VISION_API_KEY"),
-		VisionModel:  os.Getenv("VISION_API_MODEL"),
-		Port:         os.Getenv("PORT"),
-		DatabaseURL:  os.Getenv("DATABASE_URL"),
-		CSRFKey:      os.Getenv("CSRF_KEY"),
-		Hostname:     os.Getenv("HOSTNAME"),
-		Debug:        debugBool,
+		FireflyURL:         os.Getenv("FIREFLY_URL"),
+		FireflyToken:       os.Getenv("FIREFLY_TOKEN"),
+		FireflyPageLimit:   fireflyPageLimit,
+		VisionProvider:     os.Getenv("VISION_PROVIDER"),
+		VisionAPIURL:       os.Getenv("VISION_API_URL"),
+		VisionAPIKey:       os.Getenv("VISION_API_KEY"),
+		VisionModel:        os.Getenv("VISION_API_MODEL"),
+		VisionMaxDimension: visionMaxDimension,
+		VisionMaxBytes:     visionMaxBytes,
+		PromptDir:          os.Getenv("PROMPT_DIR"),
+		DedupeDateWindow:   dedupeDateWindow,
+		DedupeSimilarity:   dedupeSimilarity,
+		Port:               os.Getenv("PORT"),
+		DatabaseURL:        os.Getenv("DATABASE_URL"),
+		CSRFKey:            os.Getenv("CSRF_KEY"),
+		Hostname:           os.Getenv("HOSTNAME"),
+		Debug:              debugBool,
 	}
 
 	return config
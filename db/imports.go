@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// ImportRecord describes transactions saved to an account in one go, and the prompt template
+// version they were read with when they came from images.
+type ImportRecord struct {
+	AccountID     string
+	Transactions  int
+	PromptName    string
+	PromptVersion int
+}
+
+// LogImport records an import.
+func LogImport(db *sql.DB, rec ImportRecord) error {
+	if db == nil {
+		return nil
+	}
+	query := `
+	INSERT INTO imports (account_id, transactions, prompt_name, prompt_version, created_at)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP);
+	`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%+v]", query, rec)
+	}
+	_, err := db.Exec(query, rec.AccountID, rec.Transactions, rec.PromptName, rec.PromptVersion)
+	if err != nil {
+		return fmt.Errorf("failed to insert import record: %w", err)
+	}
+	return nil
+}
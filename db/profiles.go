@@ -0,0 +1,173 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"firefly-importer/models"
+)
+
+const profileColumns = `name, has_header, date_column, description_column, amount_column,
+	COALESCE(type_column, ''), COALESCE(debit_credit_column, ''), COALESCE(counterparty_column, ''),
+	COALESCE(category_column, ''), COALESCE(budget_column, ''), COALESCE(amount_mode, ''),
+	COALESCE(debit_amount_column, ''), COALESCE(credit_amount_column, ''), COALESCE(invert_sign, FALSE),
+	COALESCE(date_format, ''), COALESCE(decimal_separator, ''), COALESCE(encoding, ''), COALESCE(delimiter, ''),
+	COALESCE(quote, ''), COALESCE(sheet, ''), COALESCE(header_row, 0),
+	COALESCE(line_pattern, '')`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProfile(row rowScanner) (models.ImportProfile, error) {
+	var p models.ImportProfile
+	err := row.Scan(&p.Name, &p.HasHeader, &p.DateColumn, &p.DescriptionColumn, &p.AmountColumn,
+		&p.TypeColumn, &p.DebitCreditColumn, &p.CounterpartyColumn, &p.CategoryColumn, &p.BudgetColumn,
+		&p.AmountMode, &p.DebitAmountColumn, &p.CreditAmountColumn, &p.InvertSign, &p.DateFormat, &p.DecimalSeparator,
+		&p.Encoding, &p.Delimiter, &p.Quote, &p.Sheet, &p.HeaderRow, &p.LinePattern)
+	return p, err
+}
+
+// SaveProfile inserts or updates an import profile by name.
+func SaveProfile(db *sql.DB, p models.ImportProfile) error {
+	if db == nil {
+		return nil
+	}
+	query := `
+	INSERT INTO import_profiles (name, has_header, date_column, description_column, amount_column,
+		type_column, debit_credit_column, counterparty_column, category_column, budget_column,
+		amount_mode, debit_amount_column, credit_amount_column, invert_sign, date_format, decimal_separator, encoding, delimiter, quote,
+		sheet, header_row, line_pattern, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+		CURRENT_TIMESTAMP)
+	ON CONFLICT (name)
+	DO UPDATE SET has_header = EXCLUDED.has_header, date_column = EXCLUDED.date_column,
+		description_column = EXCLUDED.description_column, amount_column = EXCLUDED.amount_column,
+		type_column = EXCLUDED.type_column, debit_credit_column = EXCLUDED.debit_credit_column,
+		counterparty_column = EXCLUDED.counterparty_column, category_column = EXCLUDED.category_column,
+		budget_column = EXCLUDED.budget_column, amount_mode = EXCLUDED.amount_mode,
+		debit_amount_column = EXCLUDED.debit_amount_column, credit_amount_column = EXCLUDED.credit_amount_column,
+		invert_sign = EXCLUDED.invert_sign, date_format = EXCLUDED.date_format,
+		decimal_separator = EXCLUDED.decimal_separator, encoding = EXCLUDED.encoding, delimiter = EXCLUDED.delimiter,
+		quote = EXCLUDED.quote, sheet = EXCLUDED.sheet, header_row = EXCLUDED.header_row,
+		line_pattern = EXCLUDED.line_pattern, updated_at = EXCLUDED.updated_at;
+	`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%+v]", query, p)
+	}
+	_, err := db.Exec(query, p.Name, p.HasHeader, p.DateColumn, p.DescriptionColumn, p.AmountColumn,
+		p.TypeColumn, p.DebitCreditColumn, p.CounterpartyColumn, p.CategoryColumn, p.BudgetColumn,
+		p.AmountMode, p.DebitAmountColumn, p.CreditAmountColumn, p.InvertSign, p.DateFormat, p.DecimalSeparator,
+		p.Encoding, p.Delimiter, p.Quote, p.Sheet, p.HeaderRow, p.LinePattern)
+	if err != nil {
+		return fmt.Errorf("failed to upsert import profile: %w", err)
+	}
+	return nil
+}
+
+// GetProfiles retrieves all import profiles ordered by name.
+func GetProfiles(db *sql.DB) ([]models.ImportProfile, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	query := `SELECT ` + profileColumns + ` FROM import_profiles ORDER BY name;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s", query)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query import profiles: %w", err)
+	}
+	defer rows.Close()
+
+	var profiles []models.ImportProfile
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan import profile row: %w", err)
+		}
+		profiles = append(profiles, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating import profile rows: %w", err)
+	}
+
+	return profiles, nil
+}
+
+// GetProfile retrieves a single import profile by name. It returns nil when no profile matches.
+func GetProfile(db *sql.DB, name string) (*models.ImportProfile, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	query := `SELECT ` + profileColumns + ` FROM import_profiles WHERE name = $1;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s]", query, name)
+	}
+	p, err := scanProfile(db.QueryRow(query, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query import profile: %w", err)
+	}
+	return &p, nil
+}
+
+// SaveAccountProfile remembers the profile last used to import into an account.
+func SaveAccountProfile(db *sql.DB, accountID, profileName string) error {
+	if db == nil {
+		return nil
+	}
+	query := `
+	INSERT INTO account_profiles (account_id, profile_name, updated_at)
+	VALUES ($1, $2, CURRENT_TIMESTAMP)
+	ON CONFLICT (account_id)
+	DO UPDATE SET profile_name = EXCLUDED.profile_name, updated_at = EXCLUDED.updated_at;
+	`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s, %s]", query, accountID, profileName)
+	}
+	_, err := db.Exec(query, accountID, profileName)
+	if err != nil {
+		return fmt.Errorf("failed to upsert account profile: %w", err)
+	}
+	return nil
+}
+
+// GetAccountProfiles retrieves the last used profile name for each account, keyed by account ID.
+func GetAccountProfiles(db *sql.DB) (map[string]string, error) {
+	if db == nil {
+		return nil, nil
+	}
+	accountProfiles := make(map[string]string)
+
+	query := `SELECT account_id, profile_name FROM account_profiles;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s", query)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query account profiles: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accountID, profileName string
+		if err := rows.Scan(&accountID, &profileName); err != nil {
+			return nil, fmt.Errorf("failed to scan account profile row: %w", err)
+		}
+		accountProfiles[accountID] = profileName
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account profile rows: %w", err)
+	}
+
+	return accountProfiles, nil
+}
@@ -0,0 +1,171 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"firefly-importer/models"
+)
+
+// SavePrompt stores a prompt template as a new version of its name and returns the version.
+// When the text and kind are unchanged from the latest version, nothing is stored and that
+// version is returned. Saves of the same name are serialised with a transaction-scoped
+// advisory lock, so that concurrent saves do not pick the same version.
+func SavePrompt(db *sql.DB, p models.PromptTemplate) (int, error) {
+	if db == nil {
+		return 0, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin prompt template transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lockQuery := `SELECT pg_advisory_xact_lock(hashtext('prompt_templates:' || $1));`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s]", lockQuery, p.Name)
+	}
+	if _, err := tx.Exec(lockQuery, p.Name); err != nil {
+		return 0, fmt.Errorf("failed to lock prompt template: %w", err)
+	}
+
+	latestQuery := `SELECT kind, version, text FROM prompt_templates WHERE name = $1 ORDER BY version DESC LIMIT 1;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s]", latestQuery, p.Name)
+	}
+	var latest models.PromptTemplate
+	err = tx.QueryRow(latestQuery, p.Name).Scan(&latest.Kind, &latest.Version, &latest.Text)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("failed to query prompt template: %w", err)
+	}
+	if err == nil && latest.Text == p.Text && latest.Kind == p.Kind {
+		return latest.Version, nil
+	}
+
+	query := `
+	INSERT INTO prompt_templates (name, version, kind, text, created_at)
+	VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP);
+	`
+	version := latest.Version + 1
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s, %d, %s, %d bytes]", query, p.Name, version, p.Kind, len(p.Text))
+	}
+	if _, err := tx.Exec(query, p.Name, version, p.Kind, p.Text); err != nil {
+		return 0, fmt.Errorf("failed to insert prompt template: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit prompt template: %w", err)
+	}
+	return version, nil
+}
+
+// GetPrompts retrieves the latest version of every prompt template ordered by name.
+func GetPrompts(db *sql.DB) ([]models.PromptTemplate, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	query := `SELECT DISTINCT ON (name) name, kind, version, text FROM prompt_templates ORDER BY name, version DESC;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s", query)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prompt templates: %w", err)
+	}
+	defer rows.Close()
+
+	var prompts []models.PromptTemplate
+	for rows.Next() {
+		var p models.PromptTemplate
+		if err := rows.Scan(&p.Name, &p.Kind, &p.Version, &p.Text); err != nil {
+			return nil, fmt.Errorf("failed to scan prompt template row: %w", err)
+		}
+		prompts = append(prompts, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating prompt template rows: %w", err)
+	}
+
+	return prompts, nil
+}
+
+// GetPrompt retrieves the latest version of a prompt template by name. It returns nil when no
+// template matches.
+func GetPrompt(db *sql.DB, name string) (*models.PromptTemplate, error) {
+	if db == nil {
+		return nil, nil
+	}
+
+	query := `SELECT name, kind, version, text FROM prompt_templates WHERE name = $1 ORDER BY version DESC LIMIT 1;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s]", query, name)
+	}
+	var p models.PromptTemplate
+	err := db.QueryRow(query, name).Scan(&p.Name, &p.Kind, &p.Version, &p.Text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prompt template: %w", err)
+	}
+	return &p, nil
+}
+
+// SaveAccountPrompt remembers the prompt template last used to import images into an account.
+func SaveAccountPrompt(db *sql.DB, accountID, promptName string) error {
+	if db == nil {
+		return nil
+	}
+	query := `
+	INSERT INTO account_prompts (account_id, prompt_name, updated_at)
+	VALUES ($1, $2, CURRENT_TIMESTAMP)
+	ON CONFLICT (account_id)
+	DO UPDATE SET prompt_name = EXCLUDED.prompt_name, updated_at = EXCLUDED.updated_at;
+	`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s, %s]", query, accountID, promptName)
+	}
+	_, err := db.Exec(query, accountID, promptName)
+	if err != nil {
+		return fmt.Errorf("failed to upsert account prompt: %w", err)
+	}
+	return nil
+}
+
+// GetAccountPrompts retrieves the last used prompt template name for each account, keyed by
+// account ID.
+func GetAccountPrompts(db *sql.DB) (map[string]string, error) {
+	if db == nil {
+		return nil, nil
+	}
+	accountPrompts := make(map[string]string)
+
+	query := `SELECT account_id, prompt_name FROM account_prompts WHERE prompt_name <> '';`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s", query)
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query account prompts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var accountID, promptName string
+		if err := rows.Scan(&accountID, &promptName); err != nil {
+			return nil, fmt.Errorf("failed to scan account prompt row: %w", err)
+		}
+		accountPrompts[accountID] = promptName
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating account prompt rows: %w", err)
+	}
+
+	return accountPrompts, nil
+}
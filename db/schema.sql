@@ -5,3 +5,59 @@ CREATE TABLE IF NOT EXISTS name_mappings (
 );
 ALTER TABLE name_mappings ADD COLUMN IF NOT EXISTS budget_name TEXT DEFAULT '';
 ALTER TABLE name_mappings ADD COLUMN IF NOT EXISTS category_name TEXT DEFAULT '';
+CREATE TABLE IF NOT EXISTS import_profiles (
+	name TEXT PRIMARY KEY,
+	has_header BOOLEAN NOT NULL DEFAULT TRUE,
+	date_column TEXT NOT NULL,
+	description_column TEXT NOT NULL,
+	amount_column TEXT NOT NULL,
+	type_column TEXT DEFAULT '',
+	debit_credit_column TEXT DEFAULT '',
+	counterparty_column TEXT DEFAULT '',
+	category_column TEXT DEFAULT '',
+	budget_column TEXT DEFAULT '',
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS account_profiles (
+	account_id TEXT PRIMARY KEY,
+	profile_name TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS amount_mode TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS debit_amount_column TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS credit_amount_column TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS invert_sign BOOLEAN DEFAULT FALSE;
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS date_format TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS decimal_separator TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS encoding TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS delimiter TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS quote TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS sheet TEXT DEFAULT '';
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS header_row INTEGER DEFAULT 0;
+ALTER TABLE import_profiles ADD COLUMN IF NOT EXISTS line_pattern TEXT DEFAULT '';
+CREATE TABLE IF NOT EXISTS vision_cache (
+	cache_key TEXT PRIMARY KEY,
+	transactions JSONB NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS prompt_templates (
+	name TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	kind TEXT NOT NULL DEFAULT 'statement',
+	text TEXT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (name, version)
+);
+CREATE TABLE IF NOT EXISTS account_prompts (
+	account_id TEXT PRIMARY KEY,
+	prompt_name TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS imports (
+	id SERIAL PRIMARY KEY,
+	account_id TEXT NOT NULL,
+	transactions INTEGER NOT NULL DEFAULT 0,
+	prompt_name TEXT DEFAULT '',
+	prompt_version INTEGER DEFAULT 0,
+	created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
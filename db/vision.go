@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"firefly-importer/models"
+)
+
+// GetVisionResult retrieves the transactions cached for a vision cache key. It reports false
+// when the key is not cached.
+func GetVisionResult(db *sql.DB, key string) ([]models.Transaction, bool, error) {
+	if db == nil {
+		return nil, false, nil
+	}
+	query := `SELECT transactions FROM vision_cache WHERE cache_key = $1;`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s]", query, key)
+	}
+	var raw []byte
+	err := db.QueryRow(query, key).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query vision cache: %w", err)
+	}
+
+	var transactions []models.Transaction
+	if err := json.Unmarshal(raw, &transactions); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached vision result: %w", err)
+	}
+	return transactions, true, nil
+}
+
+// SaveVisionResult inserts or replaces the transactions cached for a vision cache key.
+func SaveVisionResult(db *sql.DB, key string, transactions []models.Transaction) error {
+	if db == nil {
+		return nil
+	}
+	raw, err := json.Marshal(transactions)
+	if err != nil {
+		return fmt.Errorf("failed to encode vision result: %w", err)
+	}
+	query := `
+	INSERT INTO vision_cache (cache_key, transactions, created_at)
+	VALUES ($1, $2, CURRENT_TIMESTAMP)
+	ON CONFLICT (cache_key)
+	DO UPDATE SET transactions = EXCLUDED.transactions, created_at = EXCLUDED.created_at;
+	`
+	if logQueries {
+		log.Printf("[DB DEBUG] Executing: %s args: [%s, %d transaction(s)]", query, key, len(transactions))
+	}
+	if _, err := db.Exec(query, key, raw); err != nil {
+		return fmt.Errorf("failed to upsert vision result: %w", err)
+	}
+	return nil
+}
@@ -4,8 +4,25 @@ import (
 	"crypto/sha256"
 	"firefly-importer/models"
 	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode"
 )
 
+// Defaults of the fuzzy matcher, used when a Matcher field is left at zero.
+const (
+	DefaultDateWindow    = 3   // Days a bank may take to post a card payment
+	DefaultMinSimilarity = 0.5 // Half of the description words in common
+)
+
+// Matcher flags transactions that are probably already in Firefly even though they do not
+// match exactly, such as a purchase posted a day later or with a truncated merchant name.
+type Matcher struct {
+	DateWindow    int     // Days before or after an existing transaction a candidate may be dated; 0 uses DefaultDateWindow, negative only compares the same day
+	MinSimilarity float64 // Description similarity from 0 to 1 a candidate needs; 0 uses DefaultMinSimilarity
+}
+
 // GenerateHash generates a SHA-256 hash for a transaction based on Date, Description, and Amount
 func GenerateHash(tx models.Transaction, description string) string {
 	// We format the amount predictably to avoid floating point inconsistencies
@@ -14,13 +31,65 @@ func GenerateHash(tx models.Transaction, description string) string {
 	return fmt.Sprintf("%x", hash)
 }
 
-// Filter compares incoming transactions against existing ones and updates their status
+// ImportID returns the deterministic import ID of a transaction: the bank's identifier when the
+// statement has one, otherwise a hash of the fields as parsed, so that later edits in Firefly
+// do not change it.
+func ImportID(tx models.Transaction) string {
+	if tx.ExternalID != "" {
+		return tx.ExternalID
+	}
+	description := tx.OriginalDescription
+	if description == "" {
+		description = tx.Description
+	}
+	return "import-" + GenerateHash(tx, description)[:32]
+}
+
+// AssignImportIDs sets the import ID of the transactions of a batch that have none. Repeats of
+// the same transaction within the batch, such as two coffees bought the same day, get their
+// position among the repeats appended, so that each is stored under its own ID and the batch
+// gets the same IDs when it is imported again.
+func AssignImportIDs(txs []models.Transaction) {
+	seen := make(map[string]int)
+	for i := range txs {
+		if txs[i].ImportID != "" {
+			continue
+		}
+		id := ImportID(txs[i])
+		seen[id]++
+		if seen[id] > 1 {
+			id = fmt.Sprintf("%s-%d", id, seen[id])
+		}
+		txs[i].ImportID = id
+	}
+}
+
+// Filter compares incoming transactions against existing ones and updates their status, using
+// the default fuzzy matching.
 func Filter(incoming []models.Transaction, existing []models.Transaction) []models.Transaction {
-	// Create a map of existing hashes for O(1) lookup
-	existingHashes := make(map[string]bool, len(existing))
-	for _, tx := range existing {
+	return Matcher{}.Filter(incoming, existing)
+}
+
+// Filter compares incoming transactions against existing ones and updates their status. Exact
+// matches are skipped, starting with those whose import ID or bank identifier is already in
+// Firefly. Of the rest, a transaction with the same amount and type as an existing
+// one within the date window and a similar enough description becomes a possible duplicate,
+// which is only imported once confirmed on the review page. Each existing transaction is
+// paired with at most one possible duplicate.
+func (m Matcher) Filter(incoming []models.Transaction, existing []models.Transaction) []models.Transaction {
+	// Create maps of existing hashes and IDs to their transaction for O(1) lookup
+	existingHashes := make(map[string]int, len(existing))
+	existingIDs := make(map[string]int)
+	existingImportIDs := make(map[string]int)
+	for j, tx := range existing {
 		hash := GenerateHash(tx, tx.Description)
-		existingHashes[hash] = true
+		existingHashes[hash] = j
+		if tx.ExternalID != "" {
+			existingIDs[tx.ExternalID] = j
+		}
+		if tx.ImportID != "" {
+			existingImportIDs[tx.ImportID] = j
+		}
 	}
 
 	// Filter incoming transactions
@@ -33,14 +102,218 @@ func Filter(incoming []models.Transaction, existing []models.Transaction) []mode
 			continue
 		}
 
-		hash := GenerateHash(tx, tx.Description)
-		mappedDescriptionHash := GenerateHash(tx, tx.SuggestedDescription)
-		if existingHashes[hash] || existingHashes[mappedDescriptionHash] {
+		// An import ID survives edits in Firefly, so it is the strongest signal
+		if j, ok := existingImportIDs[tx.ImportID]; ok && tx.ImportID != "" {
+			result[i].Status = models.StatusSkipped
+			result[i].Match = matchOf(existing[j])
+			continue
+		}
+
+		// A stable bank identifier comes next, so check it before hashing
+		if j, ok := existingIDs[tx.ExternalID]; ok && tx.ExternalID != "" {
+			result[i].Status = models.StatusSkipped
+			result[i].Match = matchOf(existing[j])
+			continue
+		}
+
+		// A hash match with a transaction imported under another ID, such as the first of two
+		// identical coffees, is not exact; the fuzzy pass below can still flag it
+		j, ok := existingHashes[GenerateHash(tx, tx.Description)]
+		if !ok || otherImport(tx, existing[j]) {
+			j, ok = existingHashes[GenerateHash(tx, tx.SuggestedDescription)]
+			ok = ok && !otherImport(tx, existing[j])
+		}
+		if ok {
 			result[i].Status = models.StatusSkipped
+			result[i].Match = matchOf(existing[j])
 		} else {
 			result[i].Status = models.StatusAdded
 		}
 	}
 
+	// Pair the remaining transactions with their most similar candidate, in statement order
+	paired := make([]bool, len(existing))
+	for i := range result {
+		if result[i].Status != models.StatusAdded {
+			continue
+		}
+		if j := m.bestCandidate(result[i], existing, paired); j >= 0 {
+			paired[j] = true
+			result[i].Status = models.StatusPossibleDuplicate
+			result[i].Match = matchOf(existing[j])
+		}
+	}
+
 	return result
 }
+
+// otherImport reports whether an existing transaction was imported under another import ID
+// than the incoming one.
+func otherImport(tx, existing models.Transaction) bool {
+	return tx.ImportID != "" && existing.ImportID != "" && tx.ImportID != existing.ImportID
+}
+
+// matchOf describes an existing transaction as the match of a duplicate.
+func matchOf(tx models.Transaction) *models.Match {
+	return &models.Match{
+		JournalID:   tx.JournalID,
+		GroupID:     tx.GroupID,
+		Date:        tx.Date,
+		Description: tx.Description,
+		Amount:      tx.Amount,
+		ImportID:    tx.ImportID,
+	}
+}
+
+// Window returns the number of days either side of an existing transaction a possible
+// duplicate may be dated.
+func (m Matcher) Window() int {
+	if m.DateWindow == 0 {
+		return DefaultDateWindow
+	}
+	return max(m.DateWindow, 0)
+}
+
+// Range returns the dates existing transactions must be compared from and to for a batch: its
+// first and last date, widened by the date window. ok is false when no transaction in the
+// batch has a valid date.
+func (m Matcher) Range(txs []models.Transaction) (start, end time.Time, ok bool) {
+	for _, tx := range txs {
+		date, err := time.Parse("2006-01-02", tx.Date)
+		if err != nil {
+			continue
+		}
+		if !ok || date.Before(start) {
+			start = date
+		}
+		if !ok || date.After(end) {
+			end = date
+		}
+		ok = true
+	}
+	if !ok {
+		return start, end, false
+	}
+	return start.AddDate(0, 0, -m.Window()), end.AddDate(0, 0, m.Window()), true
+}
+
+// bestCandidate returns the index of the unpaired existing transaction that tx most likely
+// duplicates, or -1 when none is close enough. Ties go to the nearest date.
+func (m Matcher) bestCandidate(tx models.Transaction, existing []models.Transaction, paired []bool) int {
+	minSimilarity := m.MinSimilarity
+	if minSimilarity <= 0 {
+		minSimilarity = DefaultMinSimilarity
+	}
+
+	date, err := time.Parse("2006-01-02", tx.Date)
+	if err != nil {
+		return -1
+	}
+
+	best, bestScore, bestDays := -1, 0.0, 0
+	for j, ex := range existing {
+		if paired[j] || cents(ex.Amount) != cents(tx.Amount) || !strings.EqualFold(ex.Type, tx.Type) {
+			continue
+		}
+		// Different bank identifiers mean different transactions, however alike they look
+		if tx.ExternalID != "" && ex.ExternalID != "" && tx.ExternalID != ex.ExternalID {
+			continue
+		}
+		exDate, err := time.Parse("2006-01-02", ex.Date)
+		if err != nil {
+			continue
+		}
+		days := int(math.Abs(date.Sub(exDate).Hours()) / 24)
+		if days > m.Window() {
+			continue
+		}
+
+		score := Similarity(tx.Description, ex.Description)
+		if tx.SuggestedDescription != "" {
+			score = max(score, Similarity(tx.SuggestedDescription, ex.Description))
+		}
+		if score < minSimilarity {
+			continue
+		}
+		if best < 0 || score > bestScore || (score == bestScore && days < bestDays) {
+			best, bestScore, bestDays = j, score, days
+		}
+	}
+	return best
+}
+
+// cents returns an amount in whole cents, so that amounts compare without float rounding.
+func cents(amount float64) int64 {
+	return int64(math.Round(math.Abs(amount) * 100))
+}
+
+// Similarity scores how alike two descriptions are, from 0 to 1. Descriptions are compared
+// as lower-case words without digits or punctuation, so card numbers and dates added by the
+// bank do not count. A description cut off by the bank scores 1 against the full one as long
+// as at least half of it is left, and words cut off at three letters or more count as the same
+// word.
+func Similarity(a, b string) float64 {
+	a, b = normalize(a), normalize(b)
+	if a == "" || b == "" {
+		return 0
+	}
+	short, long := a, b
+	if len(short) > len(long) {
+		short, long = long, short
+	}
+	if len(short) >= 3 && 2*len(short) >= len(long) && strings.HasPrefix(long, short) {
+		return 1
+	}
+
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	used := make([]bool, len(wordsB))
+	common := 0
+	for _, wa := range wordsA {
+		for k, wb := range wordsB {
+			if !used[k] && sameWord(wa, wb) {
+				used[k] = true
+				common++
+				break
+			}
+		}
+	}
+	return 2 * float64(common) / float64(len(wordsA)+len(wordsB))
+}
+
+// normalize lower-cases a description and reduces it to single-spaced letter-only words.
+func normalize(description string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}), " ")
+}
+
+// sameWord reports whether two words are equal or one is the other cut off after at least
+// three letters.
+func sameWord(a, b string) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	return a == b || (len(a) >= 3 && strings.HasPrefix(b, a))
+}
+
+// MergeOverlaps combines batches of transactions read from overlapping sources, such as
+// consecutive screenshots of the same account, into one list. A transaction found in several
+// batches is kept once, while repeats within a single batch are all kept, since the same
+// purchase can legitimately occur twice on one day. Transactions keep the order of the batch
+// they first appear in.
+func MergeOverlaps(batches [][]models.Transaction) []models.Transaction {
+	kept := make(map[string]int)
+	var merged []models.Transaction
+	for _, batch := range batches {
+		seen := make(map[string]int)
+		for _, tx := range batch {
+			key := GenerateHash(tx, strings.Join(strings.Fields(strings.ToLower(tx.Description)), " "))
+			seen[key]++
+			if seen[key] > kept[key] {
+				kept[key]++
+				merged = append(merged, tx)
+			}
+		}
+	}
+	return merged
+}
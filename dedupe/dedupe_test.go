@@ -2,6 +2,7 @@ package dedupe
 
 import (
 	"firefly-importer/models"
+	"math"
 	"testing"
 )
 
@@ -39,8 +40,8 @@ func TestGenerateHash(t *testing.T) {
 
 func TestFilter(t *testing.T) {
 	existing := []models.Transaction{
-		{Date: "2023-10-01", Description: "Rent", Amount: 1500.00},
-		{Date: "2023-10-02", Description: "Internet", Amount: 60.00},
+		{Date: "2023-10-01", Description: "Rent", Amount: 1500.00, JournalID: "11"},
+		{Date: "2023-10-02", Description: "Internet", Amount: 60.00, JournalID: "12"},
 	}
 
 	incoming := []models.Transaction{
@@ -59,6 +60,12 @@ func TestFilter(t *testing.T) {
 	if result[0].Status != models.StatusSkipped {
 		t.Errorf("Expected first transaction to be skipped, got %s", result[0].Status)
 	}
+	if m := result[0].Match; m == nil || m.JournalID != "11" || m.Description != "Rent" {
+		t.Errorf("Expected the skipped transaction to record its match, got %+v", m)
+	}
+	if result[1].Match != nil {
+		t.Errorf("Expected no match for a new transaction, got %+v", result[1].Match)
+	}
 
 	if result[1].Status != models.StatusAdded {
 		t.Errorf("Expected second transaction to be added, got %s", result[1].Status)
@@ -72,3 +79,205 @@ func TestFilter(t *testing.T) {
 		t.Errorf("Expected fourth transaction to retain error status, got %s", result[3].Status)
 	}
 }
+
+func TestFilterExternalID(t *testing.T) {
+	existing := []models.Transaction{
+		{Date: "2023-10-01", Description: "CARD PURCHASE 1234", Amount: 45.50, ExternalID: "FIT-1"},
+	}
+
+	incoming := []models.Transaction{
+		{Date: "2023-10-01", Description: "Grocery Store", Amount: 45.50, ExternalID: "FIT-1"}, // Same FITID, edited description
+		{Date: "2023-10-01", Description: "Grocery Store", Amount: 45.50, ExternalID: "FIT-2"}, // New
+	}
+
+	result := Filter(incoming, existing)
+
+	if result[0].Status != models.StatusSkipped {
+		t.Errorf("Expected transaction with known external ID to be skipped, got %s", result[0].Status)
+	}
+	if result[1].Status != models.StatusAdded {
+		t.Errorf("Expected transaction with new external ID to be added, got %s", result[1].Status)
+	}
+}
+
+func TestMergeOverlaps(t *testing.T) {
+	first := []models.Transaction{
+		{Date: "2023-10-01", Description: "Coffee", Amount: 3.50, Type: "withdrawal"},
+		{Date: "2023-10-01", Description: "Coffee", Amount: 3.50, Type: "withdrawal"}, // Bought twice that day
+		{Date: "2023-10-02", Description: "Bakery", Amount: 2.10, Type: "withdrawal"},
+	}
+	second := []models.Transaction{
+		{Date: "2023-10-01", Description: "COFFEE ", Amount: 3.50, Type: "withdrawal"}, // Overlap with the first screenshot
+		{Date: "2023-10-02", Description: "Bakery", Amount: 2.10, Type: "withdrawal"},  // Overlap
+		{Date: "2023-10-03", Description: "Rent", Amount: 1500, Type: "withdrawal"},    // New
+	}
+
+	merged := MergeOverlaps([][]models.Transaction{first, second})
+
+	if len(merged) != 4 {
+		t.Fatalf("Expected 4 merged transactions, got %d: %+v", len(merged), merged)
+	}
+	if merged[1].Description != "Coffee" || merged[3].Description != "Rent" {
+		t.Errorf("Expected both coffees to be kept and rent appended, got %+v", merged)
+	}
+}
+
+func TestFilterPossibleDuplicates(t *testing.T) {
+	existing := []models.Transaction{
+		{Date: "2023-10-02", Description: "AMAZON MKTPLACE PMTS", Amount: 23.99, Type: "withdrawal"},
+		{Date: "2023-10-05", Description: "Netflix.com", Amount: 12.99, Type: "withdrawal"},
+		{Date: "2023-10-10", Description: "Bakery", Amount: 4.20, Type: "withdrawal"},
+	}
+
+	incoming := []models.Transaction{
+		{Date: "2023-10-01", Description: "AMAZON MKTP 4411", Amount: 23.99, Type: "withdrawal"},        // Posted a day later, truncated
+		{Date: "2023-10-01", Description: "AMAZON MKTP 4412", Amount: 23.99, Type: "withdrawal"},        // Candidate already paired
+		{Date: "2023-10-05", Description: "Gym membership", Amount: 12.99, Type: "withdrawal"},          // Same amount, other payee
+		{Date: "2023-10-20", Description: "Bakery", Amount: 4.20, Type: "withdrawal"},                   // Outside the window
+		{Date: "2023-10-10", Description: "Bakery", Amount: 4.20, Type: "deposit"},                      // Other type
+		{Date: "2023-10-04", Description: "NETFLIX.COM 866-579", Amount: 12.990001, Type: "withdrawal"}, // Amount rounding
+	}
+
+	result := Filter(incoming, existing)
+
+	want := []models.TransactionStatus{
+		models.StatusPossibleDuplicate,
+		models.StatusAdded,
+		models.StatusAdded,
+		models.StatusAdded,
+		models.StatusAdded,
+		models.StatusPossibleDuplicate,
+	}
+	for i, status := range want {
+		if result[i].Status != status {
+			t.Errorf("Transaction %d (%s): expected %s, got %s", i, incoming[i].Description, status, result[i].Status)
+		}
+	}
+	if m := result[5].Match; m == nil || m.Date != "2023-10-05" || m.Description != "Netflix.com" || m.Amount != 12.99 {
+		t.Errorf("Expected the possible duplicate to record its match, got %+v", m)
+	}
+
+	// A wider window reaches the later bakery visit
+	result = Matcher{DateWindow: 10}.Filter(incoming[3:4], existing)
+	if result[0].Status != models.StatusPossibleDuplicate {
+		t.Errorf("Expected a possible duplicate within 10 days, got %s", result[0].Status)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"REWE Markt GmbH", "rewe markt g", 1},              // Truncated by the bank
+		{"Amazon Mktp", "AMAZON MKTPLACE PMTS", 1},          // Truncated mid-word
+		{"CARD 1234 Coffee Shop", "Coffee-Shop 05/10", 0.8}, // Two words in common out of three and two
+		{"Spotify Prem", "Spotify Premium Family", 1},
+		{"Grocery Store", "CARD PURCHASE", 0},
+		{"Bakery", "", 0},
+		{"ab", "abc", 0},                           // Too short to count as truncated
+		{"Shell", "Shellfish Restaurant", 2.0 / 3}, // Too little left to count as truncated
+		{"Amazon", "Amazon Prime Video", 0.5},
+	}
+	for _, tt := range tests {
+		if got := Similarity(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("Similarity(%q, %q): expected %.2f, got %.2f", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestMatcherRange(t *testing.T) {
+	txs := []models.Transaction{
+		{Date: "2023-10-15"},
+		{Date: "2023-09-30"},
+		{Date: "not a date"},
+		{Date: "2023-11-02"},
+	}
+
+	start, end, ok := Matcher{}.Range(txs)
+	if !ok || start.Format("2006-01-02") != "2023-09-27" || end.Format("2006-01-02") != "2023-11-05" {
+		t.Errorf("Expected 2023-09-27 to 2023-11-05, got %s to %s (ok %v)", start.Format("2006-01-02"), end.Format("2006-01-02"), ok)
+	}
+
+	start, end, _ = Matcher{DateWindow: -1}.Range(txs)
+	if start.Format("2006-01-02") != "2023-09-30" || end.Format("2006-01-02") != "2023-11-02" {
+		t.Errorf("Expected the batch dates without a window, got %s to %s", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+
+	if _, _, ok := (Matcher{}).Range(txs[2:3]); ok {
+		t.Error("Expected no range for a batch without dates")
+	}
+}
+
+func TestAssignImportIDs(t *testing.T) {
+	txs := []models.Transaction{
+		{Date: "2023-10-01", Description: "Coffee", OriginalDescription: "COFFEE BAR 12", Amount: 3.50, Type: "withdrawal"},
+		{Date: "2023-10-01", Description: "Coffee", OriginalDescription: "COFFEE BAR 12", Amount: 3.50, Type: "withdrawal"},
+		{Date: "2023-10-02", Description: "Rent", Amount: 1500, Type: "withdrawal", ExternalID: "FIT-7"},
+		{Date: "2023-10-03", Description: "Kept", ImportID: "import-kept"},
+	}
+	AssignImportIDs(txs)
+
+	if txs[0].ImportID == "" || txs[1].ImportID != txs[0].ImportID+"-2" {
+		t.Errorf("Expected repeats to get numbered IDs, got %q and %q", txs[0].ImportID, txs[1].ImportID)
+	}
+	if txs[2].ImportID != "FIT-7" {
+		t.Errorf("Expected the bank identifier as import ID, got %q", txs[2].ImportID)
+	}
+	if txs[3].ImportID != "import-kept" {
+		t.Errorf("Expected an existing import ID to be kept, got %q", txs[3].ImportID)
+	}
+
+	// Editing the description afterwards does not change the ID
+	edited := txs[0]
+	edited.Description = "Morning coffee"
+	if ImportID(edited) != txs[0].ImportID {
+		t.Errorf("Expected the ID to come from the parsed description, got %q", ImportID(edited))
+	}
+}
+
+func TestFilterImportID(t *testing.T) {
+	existing := []models.Transaction{
+		{Date: "2023-10-01", Description: "Renamed in Firefly", Amount: 45.50, Type: "withdrawal", ImportID: "import-1", JournalID: "31"},
+	}
+
+	incoming := []models.Transaction{
+		{Date: "2023-10-01", Description: "CARD PURCHASE", Amount: 45.50, Type: "withdrawal", ImportID: "import-1"}, // Imported before
+		{Date: "2023-10-01", Description: "CARD PURCHASE", Amount: 45.50, Type: "withdrawal", ImportID: "import-2"}, // New
+	}
+
+	result := Filter(incoming, existing)
+
+	if result[0].Status != models.StatusSkipped || result[0].Match == nil || result[0].Match.JournalID != "31" {
+		t.Errorf("Expected the transaction with a known import ID to be skipped, got %s %+v", result[0].Status, result[0].Match)
+	}
+	if result[1].Status != models.StatusAdded {
+		t.Errorf("Expected the transaction with a new import ID to be added, got %s", result[1].Status)
+	}
+}
+
+func TestFilterRepeatedImportID(t *testing.T) {
+	existing := []models.Transaction{
+		{Date: "2023-10-01", Description: "Coffee", Amount: 3.50, Type: "withdrawal", ImportID: "import-1"},
+	}
+
+	incoming := []models.Transaction{
+		{Date: "2023-10-01", Description: "Coffee", Amount: 3.50, Type: "withdrawal", ImportID: "import-1"},   // Imported before
+		{Date: "2023-10-01", Description: "Coffee", Amount: 3.50, Type: "withdrawal", ImportID: "import-1-2"}, // Second coffee that day
+	}
+
+	result := Filter(incoming, existing)
+
+	if result[0].Status != models.StatusSkipped {
+		t.Errorf("Expected the first coffee to be skipped, got %s", result[0].Status)
+	}
+	if result[1].Status == models.StatusSkipped {
+		t.Errorf("Expected the second coffee not to be an exact duplicate, got %s", result[1].Status)
+	}
+
+	// Without an earlier import ID the hash still decides
+	existing[0].ImportID = ""
+	if result := Filter(incoming[1:], existing); result[0].Status != models.StatusSkipped {
+		t.Errorf("Expected a hash match without import IDs to be skipped, got %s", result[0].Status)
+	}
+}
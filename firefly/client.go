@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"firefly-importer/models"
@@ -17,6 +19,7 @@ type Client struct {
 	BaseURL    string
 	Token      string
 	HTTPClient *http.Client
+	PageLimit  int // Transactions per page when listing transactions; 0 uses Firefly's default
 }
 
 // NewClient creates a new Firefly III API client
@@ -30,29 +33,119 @@ func NewClient(baseURL, token string) *Client {
 	}
 }
 
+// maxConcurrentPages limits how many pages of a listing are fetched at the same time.
+const maxConcurrentPages = 4
+
+// paginationMeta is the pagination information Firefly III adds to listings.
+type paginationMeta struct {
+	Pagination struct {
+		TotalPages  int `json:"total_pages"`
+		CurrentPage int `json:"current_page"`
+	} `json:"pagination"`
+}
+
 // fireflyTransactionResponse represents the response format for getting transactions
 type fireflyTransactionResponse struct {
 	Data []struct {
+		ID         string `json:"id"` // Transaction group
 		Attributes struct {
 			Transactions []struct {
-				Date            string `json:"date"`
-				Description     string `json:"description"`
-				Amount          string `json:"amount"` // Note: Firefly amount is often a string
-				Type            string `json:"type"`
-				SourceName      string `json:"source_name"`
-				DestinationName string `json:"destination_name"`
+				JournalID         string `json:"transaction_journal_id"`
+				ExternalID        string `json:"external_id"`        // Import ID of transactions stored by this app
+				InternalReference string `json:"internal_reference"` // Bank identifier of transactions stored by this app
+				Date              string `json:"date"`
+				Description       string `json:"description"`
+				Amount            string `json:"amount"` // Note: Firefly amount is often a string
+				Type              string `json:"type"`
+				SourceName        string `json:"source_name"`
+				DestinationName   string `json:"destination_name"`
 			} `json:"transactions"`
 		} `json:"attributes"`
 	} `json:"data"`
+	Meta paginationMeta `json:"meta"`
 }
 
 // GetRecentTransactions fetches recent transactions for deduplication purposes
 func (c *Client) GetRecentTransactions(accountID string, daysOffset int) ([]models.Transaction, error) {
-	endDate := time.Now().Format("2006-01-02")
-	startDate := time.Now().AddDate(0, 0, -daysOffset).Format("2006-01-02")
-	req, err := http.NewRequest("GET", c.BaseURL+"/accounts/"+accountID+"/transactions?start="+startDate+"&end="+endDate, nil)
+	now := time.Now()
+	return c.GetTransactions(accountID, now.AddDate(0, 0, -daysOffset), now)
+}
+
+// GetTransactions fetches the transactions of an account dated from start to end, inclusive.
+// The first page tells how many pages there are; the rest are then fetched concurrently.
+func (c *Client) GetTransactions(accountID string, start, end time.Time) ([]models.Transaction, error) {
+	endpoint := fmt.Sprintf("%s/accounts/%s/transactions?start=%s&end=%s", c.BaseURL, accountID, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	if c.PageLimit > 0 {
+		endpoint += fmt.Sprintf("&limit=%d", c.PageLimit)
+	}
+
+	var first fireflyTransactionResponse
+	if err := c.getJSON(endpoint+"&page=1", &first); err != nil {
+		return nil, err
+	}
+
+	pages := make([]fireflyTransactionResponse, max(first.Meta.Pagination.TotalPages, 1))
+	pages[0] = first
+	errs := make([]error, len(pages))
+	sem := make(chan struct{}, maxConcurrentPages)
+	var wg sync.WaitGroup
+	for i := 1; i < len(pages); i++ {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = c.getJSON(fmt.Sprintf("%s&page=%d", endpoint, i+1), &pages[i])
+		})
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch page %d: %w", i+1, err)
+		}
+	}
+
+	var transactions []models.Transaction
+
+	for _, page := range pages {
+		for _, item := range page.Data {
+			for _, tx := range item.Attributes.Transactions {
+				// Convert string amount to float handling
+				amount, amountErr := strconv.ParseFloat(tx.Amount, 64)
+				if amountErr != nil {
+					// Consider logging this error
+					continue // Skip transaction with unparseable amount
+				}
+
+				parsedDate, dateErr := time.Parse(time.RFC3339, tx.Date)
+				if dateErr != nil {
+					// Consider logging this error
+					continue // Skip transaction with unparseable date
+				}
+
+				transactions = append(transactions, models.Transaction{
+					Date:            parsedDate.Format("2006-01-02"),
+					Description:     tx.Description,
+					Amount:          amount,
+					Type:            tx.Type,
+					SourceName:      tx.SourceName,
+					DestinationName: tx.DestinationName,
+					ImportID:        tx.ExternalID,
+					ExternalID:      tx.InternalReference,
+					JournalID:       tx.JournalID,
+					GroupID:         item.ID,
+					Status:          models.StatusAdded, // existing transactions are "added"
+				})
+			}
+		}
+	}
+
+	return transactions, nil
+}
+
+// getJSON fetches a URL of the Firefly III API and decodes the JSON response into out.
+func (c *Client) getJSON(url string, out any) error {
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Authorization", "Bearer "+c.Token)
@@ -60,53 +153,29 @@ func (c *Client) GetRecentTransactions(accountID string, daysOffset int) ([]mode
 
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("unexpected status code %d and failed to read response body: %w", resp.StatusCode, err)
+			return fmt.Errorf("unexpected status code %d and failed to read response body: %w", resp.StatusCode, err)
 		}
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	var fireflyResp fireflyTransactionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&fireflyResp); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	var transactions []models.Transaction
-
-	for _, item := range fireflyResp.Data {
-		for _, tx := range item.Attributes.Transactions {
-			// Convert string amount to float handling
-			amount, amountErr := strconv.ParseFloat(tx.Amount, 64)
-			if amountErr != nil {
-				// Consider logging this error
-				continue // Skip transaction with unparseable amount
-			}
-
-			parsedDate, dateErr := time.Parse(time.RFC3339, tx.Date)
-			if dateErr != nil {
-				// Consider logging this error
-				continue // Skip transaction with unparseable date
-			}
-
-			transactions = append(transactions, models.Transaction{
-				Date:            parsedDate.Format("2006-01-02"),
-				Description:     tx.Description,
-				Amount:          amount,
-				Type:            tx.Type,
-				SourceName:      tx.SourceName,
-				DestinationName: tx.DestinationName,
-				Status:          models.StatusAdded, // existing transactions are "added"
-			})
-		}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
 	}
+	return nil
+}
 
-	return transactions, nil
+// TransactionURL returns the page of a transaction group in the Firefly III web interface,
+// assuming the API is served under /api/v1 of the same host.
+func (c *Client) TransactionURL(groupID string) string {
+	base := strings.TrimSuffix(strings.TrimRight(c.BaseURL, "/"), "/api/v1")
+	return base + "/transactions/show/" + groupID
 }
 
 // GetAccounts fetches asset accounts from Firefly III
@@ -141,9 +210,11 @@ func (c *Client) GetAccounts() ([]models.Account, error) {
 	var accounts []models.Account
 	for _, item := range fireflyResp.Data {
 		accounts = append(accounts, models.Account{
-			ID:   item.ID,
-			Name: item.Attributes.Name,
-			Type: item.Attributes.Type,
+			ID:       item.ID,
+			Name:     item.Attributes.Name,
+			Type:     item.Attributes.Type,
+			IBAN:     item.Attributes.IBAN,
+			Currency: item.Attributes.CurrencyCode,
 		})
 	}
 
@@ -159,12 +230,7 @@ type basicResource struct {
 
 type paginatedResponse struct {
 	Data []basicResource `json:"data"`
-	Meta struct {
-		Pagination struct {
-			TotalPages  int `json:"total_pages"`
-			CurrentPage int `json:"current_page"`
-		} `json:"pagination"`
-	} `json:"meta"`
+	Meta paginationMeta  `json:"meta"`
 }
 
 func (c *Client) getPaginatedBasicResources(endpoint string) ([]basicResource, error) {
@@ -172,36 +238,10 @@ func (c *Client) getPaginatedBasicResources(endpoint string) ([]basicResource, e
 	page := 1
 
 	for {
-		url := fmt.Sprintf("%s%s?page=%d", c.BaseURL, endpoint, page)
-
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		req.Header.Set("Authorization", "Bearer "+c.Token)
-		req.Header.Set("Accept", "application/vnd.api+json")
-
-		resp, err := c.HTTPClient.Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("request failed: %w", err)
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			bodyBytes, err := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if err != nil {
-				return nil, fmt.Errorf("unexpected status code %d and failed to read response body: %w", resp.StatusCode, err)
-			}
-			return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
-		}
-
 		var pageResp paginatedResponse
-		if err := json.NewDecoder(resp.Body).Decode(&pageResp); err != nil {
-			resp.Body.Close()
-			return nil, fmt.Errorf("failed to decode response: %w", err)
+		if err := c.getJSON(fmt.Sprintf("%s%s?page=%d", c.BaseURL, endpoint, page), &pageResp); err != nil {
+			return nil, err
 		}
-		resp.Body.Close()
 
 		allResources = append(allResources, pageResp.Data...)
 
@@ -252,44 +292,65 @@ func (c *Client) GetCategories() ([]models.Category, error) {
 
 // fireflyStoreTransactionRequest represents the payload to create a new transaction
 type fireflyStoreTransactionRequest struct {
+	GroupTitle   string    `json:"group_title,omitempty"` // Required by Firefly when there is more than one split
 	Transactions []storeTx `json:"transactions"`
 }
 
 type storeTx struct {
-	Date            string `json:"date"` // RFC3339
-	Description     string `json:"description"`
-	Amount          string `json:"amount"`
-	Type            string `json:"type"`
-	SourceName      string `json:"source_name,omitempty"`
-	SourceID        string `json:"source_id,omitempty"`
-	DestinationName string `json:"destination_name,omitempty"`
-	DestinationID   string `json:"destination_id,omitempty"`
-	BudgetName      string `json:"budget_name,omitempty"`
-	CategoryName    string `json:"category_name,omitempty"`
+	Date              string `json:"date"` // RFC3339
+	Description       string `json:"description"`
+	Amount            string `json:"amount"`
+	Type              string `json:"type"`
+	SourceName        string `json:"source_name,omitempty"`
+	SourceID          string `json:"source_id,omitempty"`
+	DestinationName   string `json:"destination_name,omitempty"`
+	DestinationID     string `json:"destination_id,omitempty"`
+	BudgetName        string `json:"budget_name,omitempty"`
+	CategoryName      string `json:"category_name,omitempty"`
+	ExternalID        string `json:"external_id,omitempty"`        // Import ID, matched first when deduplicating
+	InternalReference string `json:"internal_reference,omitempty"` // Bank identifier, e.g. OFX FITID
 }
 
-// StoreTransaction posts a single transaction to Firefly III
+// StoreTransaction posts a single transaction to Firefly III. A transaction with splits is
+// stored as a split transaction titled with its description.
 func (c *Client) StoreTransaction(tx models.Transaction) error {
 	dateStr := tx.Date
 	if parsedDate, err := time.ParseInLocation("2006-01-02", tx.Date, time.Local); err == nil {
 		dateStr = parsedDate.Format(time.RFC3339)
 	}
 
-	payload := fireflyStoreTransactionRequest{
-		Transactions: []storeTx{
-			{
-				Date:            dateStr,
-				Description:     tx.Description,
-				Amount:          fmt.Sprintf("%.2f", tx.Amount),
-				Type:            tx.Type,
-				SourceName:      tx.SourceName,
-				SourceID:        tx.SourceID,
-				DestinationName: tx.DestinationName,
-				DestinationID:   tx.DestinationID,
-				BudgetName:      tx.BudgetName,
-				CategoryName:    tx.CategoryName,
-			},
-		},
+	base := storeTx{
+		Date:              dateStr,
+		Description:       tx.Description,
+		Amount:            fmt.Sprintf("%.2f", tx.Amount),
+		Type:              tx.Type,
+		SourceName:        tx.SourceName,
+		SourceID:          tx.SourceID,
+		DestinationName:   tx.DestinationName,
+		DestinationID:     tx.DestinationID,
+		BudgetName:        tx.BudgetName,
+		CategoryName:      tx.CategoryName,
+		ExternalID:        tx.ImportID,
+		InternalReference: tx.ExternalID,
+	}
+
+	payload := fireflyStoreTransactionRequest{Transactions: []storeTx{base}}
+	if len(tx.Splits) > 0 {
+		payload.GroupTitle = tx.Description
+		payload.Transactions = make([]storeTx, len(tx.Splits))
+		for i, split := range tx.Splits {
+			part := base
+			part.Description = split.Description
+			part.Amount = fmt.Sprintf("%.2f", split.Amount)
+			// Parts without their own budget or category fall back to the transaction's
+			if split.BudgetName != "" {
+				part.BudgetName = split.BudgetName
+			}
+			if split.CategoryName != "" {
+				part.CategoryName = split.CategoryName
+			}
+			payload.Transactions[i] = part
+		}
 	}
 
 	bodyBytes, err := json.Marshal(payload)
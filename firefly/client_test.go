@@ -3,9 +3,12 @@ package firefly
 import (
 	"encoding/json"
 	"firefly-importer/models"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGetRecentTransactions(t *testing.T) {
@@ -29,9 +32,13 @@ func TestGetRecentTransactions(t *testing.T) {
 		mockResponse := `{
 			"data": [
 				{
+					"id": "42",
 					"attributes": {
 						"transactions": [
 							{
+								"transaction_journal_id": "57",
+								"external_id": "import-abc",
+								"internal_reference": "FIT-9",
 								"date": "2023-12-01T00:00:00+00:00",
 								"description": "Internet Bill",
 								"amount": "60.00",
@@ -76,6 +83,12 @@ func TestGetRecentTransactions(t *testing.T) {
 	if txs[0].DestinationName != "ISP" {
 		t.Errorf("Expected DestinationName ISP, got %s", txs[0].DestinationName)
 	}
+	if txs[0].JournalID != "57" || txs[0].GroupID != "42" {
+		t.Errorf("Expected journal 57 in group 42, got %s in %s", txs[0].JournalID, txs[0].GroupID)
+	}
+	if txs[0].ImportID != "import-abc" || txs[0].ExternalID != "FIT-9" {
+		t.Errorf("Expected import ID import-abc and bank ID FIT-9, got %q and %q", txs[0].ImportID, txs[0].ExternalID)
+	}
 	if txs[0].Status != models.StatusAdded {
 		t.Errorf("Expected status %s, got %s", models.StatusAdded, txs[0].Status)
 	}
@@ -112,6 +125,9 @@ func TestStoreTransaction(t *testing.T) {
 		if tx.DestinationName != "Restaurant" {
 			t.Errorf("Expected DestinationName 'Restaurant', got %s", tx.DestinationName)
 		}
+		if tx.ExternalID != "import-1" || tx.InternalReference != "FIT-1" {
+			t.Errorf("Expected external_id import-1 and internal_reference FIT-1, got %q and %q", tx.ExternalID, tx.InternalReference)
+		}
 
 		w.WriteHeader(http.StatusCreated)
 	}))
@@ -126,6 +142,8 @@ func TestStoreTransaction(t *testing.T) {
 		Type:            "withdrawal",
 		SourceName:      "Wallet",
 		DestinationName: "Restaurant",
+		ExternalID:      "FIT-1",
+		ImportID:        "import-1",
 	}
 
 	err := client.StoreTransaction(newTx)
@@ -134,6 +152,54 @@ func TestStoreTransaction(t *testing.T) {
 	}
 }
 
+func TestStoreSplitTransaction(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqPayload fireflyStoreTransactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqPayload); err != nil {
+			t.Fatalf("Failed to decode store request: %v", err)
+		}
+
+		if reqPayload.GroupTitle != "Supermarket" {
+			t.Errorf("Expected group title 'Supermarket', got %q", reqPayload.GroupTitle)
+		}
+		if len(reqPayload.Transactions) != 2 {
+			t.Fatalf("Expected 2 splits in payload, got %d", len(reqPayload.Transactions))
+		}
+
+		food, soap := reqPayload.Transactions[0], reqPayload.Transactions[1]
+		if food.Description != "Bread" || food.Amount != "3.20" || food.BudgetName != "Food" {
+			t.Errorf("Unexpected first split: %+v", food)
+		}
+		if soap.Amount != "4.30" || soap.BudgetName != "Household" || soap.CategoryName != "Groceries" {
+			t.Errorf("Unexpected second split: %+v", soap)
+		}
+		if food.SourceID != "1" || soap.SourceID != "1" || food.Type != "withdrawal" {
+			t.Errorf("Expected the splits to share the account and type, got %+v and %+v", food, soap)
+		}
+
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockServer.Close()
+
+	client := NewClient(mockServer.URL, "test-token")
+
+	err := client.StoreTransaction(models.Transaction{
+		Date:         "2023-12-05",
+		Description:  "Supermarket",
+		Amount:       7.50,
+		Type:         "withdrawal",
+		SourceID:     "1",
+		CategoryName: "Groceries",
+		Splits: []models.Split{
+			{Description: "Bread", Amount: 3.20, BudgetName: "Food"},
+			{Description: "Soap", Amount: 4.30, BudgetName: "Household"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("StoreTransaction failed: %v", err)
+	}
+}
+
 func TestGetAccounts(t *testing.T) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -184,3 +250,64 @@ func TestGetAccounts(t *testing.T) {
 		t.Errorf("Expected Name Checking Account, got %s", accounts[0].Name)
 	}
 }
+
+func TestTransactionURL(t *testing.T) {
+	for _, baseURL := range []string{"https://firefly.example.com/api/v1", "https://firefly.example.com/api/v1/"} {
+		client := NewClient(baseURL, "test-token")
+		if got := client.TransactionURL("42"); got != "https://firefly.example.com/transactions/show/42" {
+			t.Errorf("Expected the web page of group 42 for %s, got %s", baseURL, got)
+		}
+	}
+}
+
+func TestGetTransactionsPaginated(t *testing.T) {
+	var mu sync.Mutex
+	requested := make(map[string]bool)
+	failPage := ""
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("limit") != "2" {
+			t.Errorf("Expected limit 2, got %q", r.URL.Query().Get("limit"))
+		}
+		page := r.URL.Query().Get("page")
+		mu.Lock()
+		requested[page] = true
+		mu.Unlock()
+
+		if page == failPage {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		fmt.Fprintf(w, `{
+			"data": [
+				{"id": "%[1]s", "attributes": {"transactions": [
+					{"transaction_journal_id": "%[1]s", "date": "2023-12-01T00:00:00+00:00", "description": "Page %[1]s", "amount": "1.00", "type": "withdrawal"}
+				]}}
+			],
+			"meta": {"pagination": {"total_pages": 3, "current_page": %[1]s}}
+		}`, page)
+	}))
+	defer mockServer.Close()
+
+	client := NewClient(mockServer.URL, "test-token")
+	client.PageLimit = 2
+	start, end := time.Date(2023, 11, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)
+
+	txs, err := client.GetTransactions("123", start, end)
+	if err != nil {
+		t.Fatalf("GetTransactions failed: %v", err)
+	}
+	if len(txs) != 3 || len(requested) != 3 {
+		t.Fatalf("Expected 3 transactions from 3 pages, got %d from %v", len(txs), requested)
+	}
+	for i, tx := range txs {
+		if want := fmt.Sprintf("Page %d", i+1); tx.Description != want {
+			t.Errorf("Expected pages in order, got %q at %d", tx.Description, i)
+		}
+	}
+
+	failPage = "3"
+	if _, err := client.GetTransactions("123", start, end); err == nil {
+		t.Error("Expected an error when a page fails")
+	}
+}
@@ -5,7 +5,10 @@ go 1.26.0
 require (
 	github.com/gorilla/csrf v1.7.3
 	github.com/joho/godotenv v1.5.1
+	github.com/ledongthuc/pdf v0.0.0-20260907135840-6c8c28e0e8a0
 	github.com/lib/pq v1.11.2
+	golang.org/x/image v0.46.0
+	golang.org/x/text v0.42.0
 )
 
 require github.com/gorilla/securecookie v1.1.2 // indirect
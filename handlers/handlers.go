@@ -1,15 +1,19 @@
 package handlers
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"firefly-importer/config"
 	"firefly-importer/db"
@@ -22,14 +26,20 @@ import (
 )
 
 type PageData struct {
-	Accounts    []models.Account
-	Budgets     []models.Budget
-	Categories  []models.Category
-	Results     []models.Transaction
-	ResultsJSON string // safe JSON for data attribute
-	CSRFField   template.HTML
-	CSRFToken   string
-	Error       string
+	Accounts            []models.Account
+	Budgets             []models.Budget
+	Categories          []models.Category
+	Profiles            []models.ImportProfile
+	AccountProfilesJSON string // account ID -> last used profile name
+	Prompts             []models.PromptTemplate
+	DefaultPrompts      []models.PromptTemplate
+	AccountPromptsJSON  string // account ID -> last used prompt template name
+	Results             []models.Transaction
+	ResultsJSON         string // safe JSON for data attribute
+	CSRFField           template.HTML
+	CSRFToken           string
+	Error               string
+	Warnings            []string
 }
 
 type AppHandler struct {
@@ -90,10 +100,57 @@ func (h *AppHandler) renderError(w http.ResponseWriter, r *http.Request, statusC
 	w.WriteHeader(statusCode)
 
 	accounts, _ := h.Client.GetAccounts() // best-effort; ignore error here
-	renderPage(w, r, PageData{
+	data := PageData{
 		Accounts: accounts,
 		Error:    errMsg,
-	})
+	}
+	h.loadProfileData(&data)
+	renderPage(w, r, data)
+}
+
+// loadProfileData fills the import profiles and per-account profile defaults for the upload form.
+// Failures are logged only, since profiles are optional.
+func (h *AppHandler) loadProfileData(data *PageData) {
+	profiles, err := db.GetProfiles(h.DB)
+	if err != nil {
+		log.Printf("Failed to fetch import profiles (ignoring): %v", err)
+	}
+	data.Profiles = profiles
+
+	accountProfiles, err := db.GetAccountProfiles(h.DB)
+	if err != nil {
+		log.Printf("Failed to fetch account profiles (ignoring): %v", err)
+	}
+	if accountProfiles == nil {
+		accountProfiles = map[string]string{}
+	}
+	jsonBytes, err := json.Marshal(accountProfiles)
+	if err != nil {
+		log.Printf("Failed to encode account profiles (ignoring): %v", err)
+		return
+	}
+	data.AccountProfilesJSON = string(jsonBytes)
+
+	prompts, err := db.GetPrompts(h.DB)
+	if err != nil {
+		log.Printf("Failed to fetch prompt templates (ignoring): %v", err)
+	}
+	data.Prompts = prompts
+	data.DefaultPrompts = []models.PromptTemplate{parser.DefaultStatementPrompt, parser.DefaultReceiptPrompt}
+
+	accountPrompts, err := db.GetAccountPrompts(h.DB)
+	if err != nil {
+		log.Printf("Failed to fetch account prompts (ignoring): %v", err)
+	}
+	if accountPrompts == nil {
+		accountPrompts = map[string]string{}
+	}
+	jsonBytes, err = json.Marshal(accountPrompts)
+	if err != nil {
+		log.Printf("Failed to encode account prompts (ignoring): %v", err)
+		return
+	}
+	data.AccountPromptsJSON = string(jsonBytes)
 }
 
 // IndexHandler handles GET /
@@ -104,7 +161,99 @@ func (h *AppHandler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	renderPage(w, r, PageData{Accounts: accounts})
+	data := PageData{Accounts: accounts}
+	h.loadProfileData(&data)
+	renderPage(w, r, data)
+}
+
+// ProfileHandler handles POST /profiles, creating or updating an import profile.
+func (h *AppHandler) ProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, r, http.StatusBadRequest, "Failed to parse form", err)
+		return
+	}
+
+	profile := models.ImportProfile{
+		Name:               strings.TrimSpace(r.FormValue("name")),
+		HasHeader:          r.FormValue("has_header") != "",
+		DateColumn:         strings.TrimSpace(r.FormValue("date_column")),
+		DescriptionColumn:  strings.TrimSpace(r.FormValue("description_column")),
+		AmountColumn:       strings.TrimSpace(r.FormValue("amount_column")),
+		TypeColumn:         strings.TrimSpace(r.FormValue("type_column")),
+		DebitCreditColumn:  strings.TrimSpace(r.FormValue("debit_credit_column")),
+		CounterpartyColumn: strings.TrimSpace(r.FormValue("counterparty_column")),
+		CategoryColumn:     strings.TrimSpace(r.FormValue("category_column")),
+		BudgetColumn:       strings.TrimSpace(r.FormValue("budget_column")),
+		AmountMode:         r.FormValue("amount_mode"),
+		DebitAmountColumn:  strings.TrimSpace(r.FormValue("debit_amount_column")),
+		CreditAmountColumn: strings.TrimSpace(r.FormValue("credit_amount_column")),
+		InvertSign:         r.FormValue("invert_sign") != "",
+		DateFormat:         strings.TrimSpace(r.FormValue("date_format")),
+		DecimalSeparator:   r.FormValue("decimal_separator"),
+		Encoding:           r.FormValue("encoding"),
+		Delimiter:          r.FormValue("delimiter"),
+		Quote:              r.FormValue("quote"),
+		Sheet:              strings.TrimSpace(r.FormValue("sheet")),
+		LinePattern:        strings.TrimSpace(r.FormValue("line_pattern")),
+	}
+
+	if v := strings.TrimSpace(r.FormValue("header_row")); v != "" {
+		headerRow, err := strconv.Atoi(v)
+		if err != nil {
+			h.renderError(w, r, http.StatusBadRequest, "Header row must be a number", err)
+			return
+		}
+		profile.HeaderRow = headerRow
+	}
+
+	if profile.Name == "" {
+		h.renderError(w, r, http.StatusBadRequest, "Profile name is required", nil)
+		return
+	}
+	if err := parser.ValidateProfile(profile); err != nil {
+		h.renderError(w, r, http.StatusBadRequest, "Invalid import profile", err)
+		return
+	}
+	if h.DB == nil {
+		h.renderError(w, r, http.StatusServiceUnavailable, "Import profiles require a database connection", nil)
+		return
+	}
+
+	if err := db.SaveProfile(h.DB, profile); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Failed to save import profile", err)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// PromptHandler handles POST /prompts, saving a new version of a prompt template.
+func (h *AppHandler) PromptHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		h.renderError(w, r, http.StatusBadRequest, "Failed to parse form", err)
+		return
+	}
+
+	prompt := models.PromptTemplate{
+		Name: strings.TrimSpace(r.FormValue("name")),
+		Kind: r.FormValue("kind"),
+		Text: strings.TrimSpace(strings.ReplaceAll(r.FormValue("text"), "\r\n", "\n")),
+	}
+	if err := parser.ValidatePrompt(prompt); err != nil {
+		h.renderError(w, r, http.StatusBadRequest, "Invalid prompt template", err)
+		return
+	}
+	if h.DB == nil {
+		h.renderError(w, r, http.StatusServiceUnavailable, "Prompt templates require a database connection", nil)
+		return
+	}
+
+	if _, err := db.SavePrompt(h.DB, prompt); err != nil {
+		h.renderError(w, r, http.StatusInternalServerError, "Failed to save prompt template", err)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // UploadHandler handles POST /upload
@@ -124,70 +273,242 @@ func (h *AppHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, header, err := r.FormFile("file")
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		h.renderError(w, r, http.StatusBadRequest, "Failed to get file from form", http.ErrMissingFile)
+		return
+	}
+	fileDates := r.MultipartForm.Value["file_date"]
+
+	compareRange, err := parseDateRange(r.FormValue("dedupe_start"), r.FormValue("dedupe_end"))
 	if err != nil {
-		h.renderError(w, r, http.StatusBadRequest, "Failed to get file from form", err)
+		h.renderError(w, r, http.StatusBadRequest, "Invalid duplicate check range", err)
 		return
 	}
-	defer file.Close()
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	fileDate := r.FormValue("file_date")
+	// Detect every format before parsing anything, so an unsupported file fails fast
+	uploads := make([]upload, 0, len(files))
+	needsProfile, needsVision := false, false
+	for i, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			h.renderError(w, r, http.StatusBadRequest, "Failed to get file from form", err)
+			return
+		}
+		defer file.Close()
 
-	var parsedTransactions []models.Transaction
-	var parseErr error
+		head, content, err := parser.ReadHead(file)
+		if err != nil {
+			h.renderError(w, r, http.StatusBadRequest, "Failed to read uploaded file", err)
+			return
+		}
 
-	switch ext {
-	case ".csv":
-		parsedTransactions, parseErr = parser.ParseCSV(file)
-	case ".png", ".jpg", ".jpeg":
-		parsedTransactions, parseErr = parser.ParseImage(file, fileDate, h.Config.VisionAPIURL, h.Config.VisionAPIKey, h.Config.VisionModel)
-	default:
-		h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Unsupported file type: %q", ext), nil)
-		return
+		p := parser.Detect(fh.Filename, head)
+		if p == nil {
+			h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Unsupported file: %q is not in a recognized format", fh.Filename), nil)
+			return
+		}
+
+		// Each file carries its own modification date; older clients send a single one
+		var fileDate string
+		if i < len(fileDates) {
+			fileDate = fileDates[i]
+		} else if len(fileDates) > 0 {
+			fileDate = fileDates[0]
+		}
+
+		uploads = append(uploads, upload{filename: fh.Filename, fileDate: fileDate, parser: p, content: content})
+		needsProfile = needsProfile || parser.UsesProfile(p)
+		needsVision = needsVision || parser.UsesVision(p)
 	}
 
-	if parseErr != nil {
-		h.renderError(w, r, http.StatusInternalServerError, "Failed to parse file", parseErr)
-		return
+	opts := parser.Options{
+		Profile: parser.DefaultProfile,
+		Vision: parser.VisionConfig{
+			Provider:     h.Config.VisionProvider,
+			APIURL:       h.Config.VisionAPIURL,
+			APIKey:       h.Config.VisionAPIKey,
+			Model:        h.Config.VisionModel,
+			MaxDimension: h.Config.VisionMaxDimension,
+			MaxBytes:     h.Config.VisionMaxBytes,
+			Refresh:      r.FormValue("refresh_vision") != "",
+		},
+		Receipts: r.FormValue("image_mode") == "receipt",
+	}
+	if h.DB != nil {
+		opts.Vision.Cache = visionCache{h.DB}
 	}
 
-	// Fetch transaction name mappings and apply them
-	mappings, err := db.GetMappings(h.DB)
-	if err != nil {
-		log.Printf("Failed to fetch name mappings (ignoring): %v", err)
-	} else if len(mappings) > 0 {
-		for i, tx := range parsedTransactions {
-			if m, ok := mappings[tx.OriginalDescription]; ok {
-				parsedTransactions[i].SuggestedDescription = m.NewName
-				parsedTransactions[i].SuggestedBudget = m.BudgetName
-				parsedTransactions[i].SuggestedCategory = m.CategoryName
+	if needsProfile {
+		profileName := r.FormValue("profile")
+		if profileName != "" {
+			profile, err := db.GetProfile(h.DB, profileName)
+			if err != nil {
+				h.renderError(w, r, http.StatusInternalServerError, "Failed to load import profile", err)
+				return
+			}
+			if profile == nil {
+				h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown import profile: %q", profileName), nil)
+				return
 			}
+			opts.Profile = *profile
+		}
+		if err := db.SaveAccountProfile(h.DB, accountIDStr, profileName); err != nil {
+			log.Printf("Failed to remember profile for account %s (ignoring): %v", accountIDStr, err)
 		}
 	}
 
-	// Fetch existing transactions for deduplication
-	existingTransactions, err := h.Client.GetRecentTransactions(accountIDStr, 30)
+	// Fetch accounts for IBAN matching, the prompt and the form dropdown
+	fireflyAccounts, err := h.Client.GetAccounts()
 	if err != nil {
-		h.renderError(w, r, http.StatusInternalServerError, "Failed to fetch recent transactions", err)
+		// non-fatal; statements fall back to the selected account
+		log.Printf("Failed to re-fetch accounts: %v", err)
+	}
+
+	var categories []models.Category
+	if needsVision {
+		kind := models.PromptKindStatement
+		if opts.Receipts {
+			kind = models.PromptKindReceipt
+		}
+		promptName := r.FormValue("prompt")
+		if promptName != "" {
+			prompt, err := db.GetPrompt(h.DB, promptName)
+			if err != nil {
+				h.renderError(w, r, http.StatusInternalServerError, "Failed to load prompt template", err)
+				return
+			}
+			if prompt == nil {
+				h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Unknown prompt template: %q", promptName), nil)
+				return
+			}
+			if prompt.Kind != kind {
+				h.renderError(w, r, http.StatusBadRequest, fmt.Sprintf("Prompt template %q is written for %s images, not %s images", promptName, prompt.Kind, kind), nil)
+				return
+			}
+			opts.Vision.Prompt = *prompt
+		}
+		if err := db.SaveAccountPrompt(h.DB, accountIDStr, promptName); err != nil {
+			log.Printf("Failed to remember prompt for account %s (ignoring): %v", accountIDStr, err)
+		}
+
+		// Prompts can mention the account, its currency and the known categories
+		for _, a := range fireflyAccounts {
+			if a.ID == accountIDStr {
+				opts.Vision.Account = a.Name
+				opts.Vision.Currency = a.Currency
+			}
+		}
+		categories, err = h.Client.GetCategories()
+		if err != nil {
+			log.Printf("Failed to fetch categories for the prompt (ignoring): %v", err)
+		}
+		for _, c := range categories {
+			opts.Vision.Categories = append(opts.Vision.Categories, c.Name)
+		}
+	}
+
+	var warnings []string
+	var accounts []accountStatements
+	byIBAN := make(map[string]int)
+	parsedFiles := 0
+	var firstErr error
+
+	for i, result := range parseUploads(uploads, opts) {
+		u := uploads[i]
+		if result.err != nil {
+			if len(uploads) == 1 {
+				h.renderError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to parse %s file", u.parser.Name()), result.err)
+				return
+			}
+			log.Printf("Failed to parse %s as %s: %v", u.filename, u.parser.Name(), result.err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", u.filename, result.err)
+			}
+			warnings = append(warnings, fmt.Sprintf("Failed to parse %s as %s: %v. Its transactions are missing.", u.filename, u.parser.Name(), result.err))
+			continue
+		}
+		parsedFiles++
+
+		for _, stmt := range result.statements {
+			if err := stmt.Reconcile(); err != nil {
+				warnings = append(warnings, fmt.Sprintf("Statement does not reconcile: %v. Some transactions may be missing.", err))
+			}
+
+			iban := parser.NormalizeIBAN(stmt.IBAN)
+			idx, ok := byIBAN[iban]
+			if !ok {
+				idx = len(accounts)
+				byIBAN[iban] = idx
+				accounts = append(accounts, accountStatements{iban: iban})
+			}
+			if parser.ReadsScreenshots(u.parser) {
+				accounts[idx].screenshots = append(accounts[idx].screenshots, stmt.Transactions)
+			} else {
+				accounts[idx].transactions = append(accounts[idx].transactions, stmt.Transactions...)
+			}
+			accounts[idx].diagnostics = append(accounts[idx].diagnostics, stmt.Diagnostics...)
+		}
+	}
+
+	if parsedFiles == 0 {
+		h.renderError(w, r, http.StatusInternalServerError, fmt.Sprintf("Failed to parse all %d files", len(uploads)), firstErr)
 		return
 	}
 
-	// Run deduplication filter
-	results := dedupe.Filter(parsedTransactions, existingTransactions)
+	// Fetch transaction name mappings
+	mappings, err := db.GetMappings(h.DB)
+	if err != nil {
+		log.Printf("Failed to fetch name mappings (ignoring): %v", err)
+	}
+
+	var results []models.Transaction
 
-	// Assign source/destination account ID based on transaction type
-	for i, tx := range results {
-		if tx.Status == models.StatusAdded {
-			if strings.ToLower(tx.Type) == "withdrawal" {
-				tx.SourceID = accountIDStr
+	for _, account := range accounts {
+		accountID := accountIDStr
+		if account.iban != "" {
+			if matched, ok := accountForIBAN(fireflyAccounts, account.iban); ok {
+				accountID = matched.ID
 			} else {
-				tx.DestinationID = accountIDStr
+				warnings = append(warnings, fmt.Sprintf("No Firefly asset account has IBAN %s; its transactions were matched against the selected account.", account.iban))
 			}
-			results[i] = tx
+		}
+
+		// Overlapping screenshots list the same transactions more than once. Other statements
+		// are not merged, as identical transactions in them are real.
+		total := 0
+		for _, batch := range account.screenshots {
+			total += len(batch)
+		}
+		screenshots := dedupe.MergeOverlaps(account.screenshots)
+		if merged := total - len(screenshots); merged > 0 {
+			warnings = append(warnings, fmt.Sprintf("Merged %d transaction(s) that appeared in more than one screenshot.", merged))
+		}
+		transactions := append(account.transactions, screenshots...)
+
+		accountResults, err := h.prepareTransactions(transactions, accountID, mappings, compareRange)
+		if err != nil {
+			h.renderError(w, r, http.StatusInternalServerError, "Failed to fetch recent transactions", err)
+			return
+		}
+		results = append(results, accountResults...)
+
+		if len(account.diagnostics) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%d row(s) could not be parsed. They are listed as errors below; fix them inline to include them in the import.", len(account.diagnostics)))
+			results = append(results, diagnosticTransactions(account.diagnostics, accountID)...)
 		}
 	}
 
+	uncertain := 0
+	for _, tx := range results {
+		if tx.Extraction != nil && len(tx.Extraction.LowConfidence) > 0 {
+			uncertain++
+		}
+	}
+	if uncertain > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d transaction(s) were read from images with low confidence. The uncertain fields are highlighted; check them against the image.", uncertain))
+	}
+
 	// Encode results as JSON for the inline <script> block
 	jsonBytes, err := json.Marshal(results)
 	if err != nil {
@@ -195,31 +516,226 @@ func (h *AppHandler) UploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Fetch accounts again for the form dropdown
-	accounts, err := h.Client.GetAccounts()
-	if err != nil {
-		// non-fatal; the upload result is more important
-		log.Printf("Failed to re-fetch accounts: %v", err)
-	}
-
 	// Fetch budgets and categories for datalists
 	budgets, err := h.Client.GetBudgets()
 	if err != nil {
 		log.Printf("Failed to re-fetch budgets: %v", err)
 	}
 
-	categories, err := h.Client.GetCategories()
-	if err != nil {
-		log.Printf("Failed to re-fetch categories: %v", err)
+	if categories == nil {
+		categories, err = h.Client.GetCategories()
+		if err != nil {
+			log.Printf("Failed to re-fetch categories: %v", err)
+		}
 	}
 
-	renderPage(w, r, PageData{
-		Accounts:    accounts,
+	data := PageData{
+		Accounts:    fireflyAccounts,
 		Budgets:     budgets,
 		Categories:  categories,
 		Results:     results,
 		ResultsJSON: string(jsonBytes),
-	})
+		Warnings:    warnings,
+	}
+	h.loadProfileData(&data)
+	renderPage(w, r, data)
+}
+
+// visionCache keeps vision results in the database, so re-uploading a screenshot after a failed
+// save does not pay for another Vision API call.
+type visionCache struct {
+	db *sql.DB
+}
+
+func (c visionCache) GetVisionResult(key string) ([]models.Transaction, bool, error) {
+	return db.GetVisionResult(c.db, key)
+}
+
+func (c visionCache) SaveVisionResult(key string, transactions []models.Transaction) error {
+	return db.SaveVisionResult(c.db, key, transactions)
+}
+
+// maxConcurrentParses limits how many uploaded files are parsed at once, since every image
+// is sent to the Vision API.
+const maxConcurrentParses = 4
+
+// upload is an uploaded file together with the parser detected for it.
+type upload struct {
+	filename string
+	fileDate string
+	parser   parser.Parser
+	content  io.Reader
+}
+
+// parsedUpload is the outcome of parsing one upload.
+type parsedUpload struct {
+	statements []parser.Statement
+	err        error
+}
+
+// parseUploads parses the uploaded files concurrently. Results are in upload order.
+func parseUploads(uploads []upload, opts parser.Options) []parsedUpload {
+	results := make([]parsedUpload, len(uploads))
+	sem := make(chan struct{}, maxConcurrentParses)
+	var wg sync.WaitGroup
+	for i, u := range uploads {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			fileOpts := opts
+			fileOpts.FileDate = u.fileDate
+			results[i].statements, results[i].err = u.parser.Parse(u.content, fileOpts)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+// accountStatements collects the transactions of all uploaded statements for one IBAN.
+// Screenshots are kept one batch per file, to merge their overlaps; the transactions of other
+// statements are kept as they are. Statements without an IBAN belong to the selected account.
+type accountStatements struct {
+	iban         string
+	transactions []models.Transaction
+	screenshots  [][]models.Transaction
+	diagnostics  []parser.Diagnostic
+}
+
+// accountForIBAN finds the Firefly account whose IBAN matches a statement's IBAN.
+func accountForIBAN(accounts []models.Account, iban string) (models.Account, bool) {
+	want := parser.NormalizeIBAN(iban)
+	for _, a := range accounts {
+		if a.IBAN != "" && parser.NormalizeIBAN(a.IBAN) == want {
+			return a, true
+		}
+	}
+	return models.Account{}, false
+}
+
+// defaultCompareDays is how far back Firefly transactions are compared when a batch has no
+// dates to go by.
+const defaultCompareDays = 30
+
+// dateRange is a period of Firefly transactions to compare an import against. A zero end is
+// left to be derived from the imported transactions.
+type dateRange struct {
+	Start, End time.Time
+}
+
+// parseDateRange reads the optional YYYY-MM-DD ends of a date range from the upload form.
+func parseDateRange(start, end string) (dateRange, error) {
+	var rng dateRange
+	var err error
+	if start = strings.TrimSpace(start); start != "" {
+		if rng.Start, err = time.Parse("2006-01-02", start); err != nil {
+			return rng, fmt.Errorf("start date %q is not in YYYY-MM-DD format", start)
+		}
+	}
+	if end = strings.TrimSpace(end); end != "" {
+		if rng.End, err = time.Parse("2006-01-02", end); err != nil {
+			return rng, fmt.Errorf("end date %q is not in YYYY-MM-DD format", end)
+		}
+	}
+	if !rng.Start.IsZero() && !rng.End.IsZero() && rng.End.Before(rng.Start) {
+		return rng, fmt.Errorf("end date %s is before start date %s", end, start)
+	}
+	return rng, nil
+}
+
+// prepareTransactions applies name mappings, deduplicates against the account's Firefly
+// transactions and assigns the account as source or destination. Transactions are compared
+// against the period the batch covers, widened by the duplicate date window, unless the ends
+// of compareRange override it.
+func (h *AppHandler) prepareTransactions(parsed []models.Transaction, accountID string, mappings map[string]db.Mapping, compareRange dateRange) ([]models.Transaction, error) {
+	for i, tx := range parsed {
+		if m, ok := mappings[tx.OriginalDescription]; ok {
+			parsed[i].SuggestedDescription = m.NewName
+			// Keep budget/category suggestions from the statement unless the mapping overrides them
+			if m.BudgetName != "" {
+				parsed[i].SuggestedBudget = m.BudgetName
+			}
+			if m.CategoryName != "" {
+				parsed[i].SuggestedCategory = m.CategoryName
+			}
+		}
+	}
+
+	matcher := dedupe.Matcher{DateWindow: h.Config.DedupeDateWindow, MinSimilarity: h.Config.DedupeSimilarity}
+
+	// Fetch existing transactions for deduplication
+	start, end, ok := matcher.Range(parsed)
+	if !ok {
+		end = time.Now()
+		start = end.AddDate(0, 0, -defaultCompareDays)
+	}
+	if !compareRange.Start.IsZero() {
+		start = compareRange.Start
+	}
+	if !compareRange.End.IsZero() {
+		end = compareRange.End
+	}
+	existingTransactions, err := h.Client.GetTransactions(accountID, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Run deduplication filter; the import IDs are taken from the fields as parsed, before any edits
+	dedupe.AssignImportIDs(parsed)
+	results := matcher.Filter(parsed, existingTransactions)
+
+	// Assign source/destination account ID based on transaction type; duplicates need one too
+	// in case they are imported anyway
+	for i := range results {
+		results[i].AccountID = accountID
+		if results[i].Status != models.StatusError {
+			assignAccount(&results[i], accountID)
+		}
+		if m := results[i].Match; m != nil && m.GroupID != "" {
+			m.URL = h.Client.TransactionURL(m.GroupID)
+		}
+	}
+
+	return results, nil
+}
+
+// assignAccount sets the account as source of a withdrawal or destination of a deposit.
+func assignAccount(tx *models.Transaction, accountID string) {
+	if strings.ToLower(tx.Type) == "withdrawal" {
+		tx.SourceID = accountID
+	} else {
+		tx.DestinationID = accountID
+	}
+}
+
+// diagnosticTransactions turns rows that failed to parse into error rows for the review page.
+// They are not deduplicated and only get an account assigned once fixed and saved.
+func diagnosticTransactions(diags []parser.Diagnostic, accountID string) []models.Transaction {
+	txs := make([]models.Transaction, 0, len(diags))
+	for _, d := range diags {
+		tx := d.Transaction
+		tx.Status = models.StatusError
+		tx.ParseError = d.Reason
+		tx.SourceLine = d.Line
+		tx.RawRecord = d.Raw
+		tx.AccountID = accountID
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// validateFixedTransaction checks a row that failed to parse and was corrected on the review page.
+func validateFixedTransaction(tx models.Transaction) error {
+	if _, err := time.Parse("2006-01-02", tx.Date); err != nil {
+		return fmt.Errorf("date %q is not in YYYY-MM-DD format", tx.Date)
+	}
+	if tx.Amount <= 0 {
+		return fmt.Errorf("amount must be positive, got %.2f", tx.Amount)
+	}
+	if tx.Type != "withdrawal" && tx.Type != "deposit" {
+		return fmt.Errorf("type must be withdrawal or deposit, got %q", tx.Type)
+	}
+	return nil
 }
 
 // SaveRequest represents the payload expected by SaveHandler
@@ -227,6 +743,22 @@ type SaveRequest struct {
 	Transactions []models.Transaction `json:"transactions"`
 }
 
+// countImport adds a saved transaction to the import record of its account and prompt version.
+func countImport(imports []db.ImportRecord, tx models.Transaction) []db.ImportRecord {
+	rec := db.ImportRecord{AccountID: tx.AccountID}
+	if tx.Extraction != nil {
+		rec.PromptName, rec.PromptVersion = tx.Extraction.PromptName, tx.Extraction.PromptVersion
+	}
+	for i := range imports {
+		if imports[i].AccountID == rec.AccountID && imports[i].PromptName == rec.PromptName && imports[i].PromptVersion == rec.PromptVersion {
+			imports[i].Transactions++
+			return imports
+		}
+	}
+	rec.Transactions = 1
+	return append(imports, rec)
+}
+
 // SaveHandler handles POST /save
 func (h *AppHandler) SaveHandler(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -251,9 +783,35 @@ func (h *AppHandler) SaveHandler(w http.ResponseWriter, r *http.Request) {
 	addedCount := 0
 	errorCount := 0
 	var firstErr error
+	var imports []db.ImportRecord
 
 	for _, tx := range req.Transactions {
 		if tx.Status == models.StatusAdded {
+			if tx.ParseError != "" {
+				// Rows fixed inline on the review page still need checking and an account
+				if err := validateFixedTransaction(tx); err != nil {
+					log.Printf("SaveHandler: fixed row from line %d is still invalid: %v", tx.SourceLine, err)
+					if firstErr == nil {
+						firstErr = err
+					}
+					errorCount++
+					continue
+				}
+				if tx.SourceID == "" && tx.DestinationID == "" && tx.AccountID != "" {
+					assignAccount(&tx, tx.AccountID)
+				}
+				if tx.ImportID == "" {
+					tx.ImportID = dedupe.ImportID(tx)
+				}
+			}
+			// A duplicate imported anyway must not share the import ID of the transaction it
+			// matched, or later imports could not tell the two apart. The suffix is random so that
+			// the same row imported anyway again gets yet another ID
+			if tx.Match != nil && tx.ImportID != "" && tx.ImportID == tx.Match.ImportID {
+				suffix := make([]byte, 4)
+				rand.Read(suffix)
+				tx.ImportID += "-override-" + hex.EncodeToString(suffix)
+			}
 			if err := h.Client.StoreTransaction(tx); err != nil {
 				log.Printf("SaveHandler: failed to store transaction %q: %v", tx.Description, err)
 				if firstErr == nil {
@@ -262,6 +820,7 @@ func (h *AppHandler) SaveHandler(w http.ResponseWriter, r *http.Request) {
 				errorCount++
 			} else {
 				addedCount++
+				imports = countImport(imports, tx)
 				// If the description was edited mapping to a new name or budget/category were added, save the mapping
 				if tx.OriginalDescription != "" && (tx.OriginalDescription != tx.Description || tx.BudgetName != "" || tx.CategoryName != "") {
 					if err := db.SaveMapping(h.DB, tx.OriginalDescription, tx.Description, tx.BudgetName, tx.CategoryName); err != nil {
@@ -272,6 +831,12 @@ func (h *AppHandler) SaveHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	for _, rec := range imports {
+		if err := db.LogImport(h.DB, rec); err != nil {
+			log.Printf("Failed to log import into account %s (ignoring): %v", rec.AccountID, err)
+		}
+	}
+
 	if errorCount > 0 && addedCount == 0 {
 		// All transactions failed — report as an error
 		renderSaveResult(w, SaveResultData{
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"firefly-importer/config"
 	"firefly-importer/firefly"
+	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 )
@@ -80,3 +86,424 @@ func TestSaveHandler(t *testing.T) {
 		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
 	}
 }
+
+func TestSaveHandlerImportAnyway(t *testing.T) {
+	var stored []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Transactions []struct {
+				ExternalID string `json:"external_id"`
+			} `json:"transactions"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		stored = append(stored, payload.Transactions[0].ExternalID)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	body := `{"transactions": [
+		{"date": "2023-12-01", "description": "Coffee", "amount": 3.5, "type": "withdrawal", "source_id": "1", "status": "Added",
+			"import_id": "import-1", "match": {"import_id": "import-1"}},
+		{"date": "2023-12-01", "description": "Bakery", "amount": 2.1, "type": "withdrawal", "source_id": "1", "status": "Added",
+			"import_id": "import-2", "match": {"import_id": "import-9"}},
+		{"date": "2023-12-01", "description": "Coffee", "amount": 3.5, "type": "withdrawal", "source_id": "1", "status": "Added",
+			"import_id": "import-1", "match": {"import_id": "import-1"}}
+	]}`
+	req, err := http.NewRequest("POST", "/save", strings.NewReader("payload="+url.QueryEscape(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	appHandler.SaveHandler(rr, req)
+
+	if len(stored) != 3 || !strings.HasPrefix(stored[0], "import-1-override-") || stored[1] != "import-2" {
+		t.Fatalf("Expected only the duplicates of import-1 to get a new import ID, got %v", stored)
+	}
+	if stored[2] == stored[0] || !strings.HasPrefix(stored[2], "import-1-override-") {
+		t.Errorf("Expected each duplicate imported anyway to get its own import ID, got %v", stored)
+	}
+}
+
+func TestSaveHandlerFixedRows(t *testing.T) {
+	var stored []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		stored = append(stored, string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	body := `{"transactions": [
+		{"date": "2023-12-01", "description": "Fixed", "amount": 10.0, "type": "withdrawal", "status": "Added", "account_id": "3", "parse_error": "invalid amount", "source_line": 4},
+		{"date": "01.12.2023", "description": "Still broken", "amount": 10.0, "type": "withdrawal", "status": "Added", "account_id": "3", "parse_error": "invalid date", "source_line": 5}
+	]}`
+	req := httptest.NewRequest("POST", "/save", strings.NewReader("payload="+body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	appHandler.SaveHandler(rr, req)
+
+	if len(stored) != 1 {
+		t.Fatalf("Expected 1 stored transaction, got %d", len(stored))
+	}
+	if !strings.Contains(stored[0], `"source_id":"3"`) {
+		t.Errorf("Expected fixed row to be assigned to account 3, got %s", stored[0])
+	}
+	if !strings.Contains(rr.Body.String(), "Saved 1, but 1 failed") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+func TestProfileHandlerValidation(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"data": []}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	cfg := &config.Config{}
+	appHandler := NewAppHandler(client, cfg, nil)
+
+	// Missing amount column
+	form := "name=Bank&date_column=Date&description_column=Memo&type_column=Type"
+	req, err := http.NewRequest("POST", "/profiles", strings.NewReader(form))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	appHandler.ProfileHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "profile must map an amount column") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+func TestPromptHandlerValidation(t *testing.T) {
+	client := firefly.NewClient("http://localhost", "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	form := url.Values{"name": {"bank-app"}, "kind": {"statement"}, "text": {"Read {{.Balance}}"}}
+	req, err := http.NewRequest("POST", "/prompts", strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	appHandler.PromptHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if !strings.Contains(rr.Body.String(), "Invalid prompt template") {
+		t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+	}
+}
+
+// newUploadRequest builds a multipart POST /upload request with a single file.
+func newUploadRequest(t *testing.T, fields map[string]string, filename, content string) *http.Request {
+	t.Helper()
+	return newMultiUploadRequest(t, fields, uploadFile{filename, content})
+}
+
+type uploadFile struct {
+	name    string
+	content string
+}
+
+// newMultiUploadRequest builds a multipart POST /upload request with several files.
+func newMultiUploadRequest(t *testing.T, fields map[string]string, files ...uploadFile) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := mw.WriteField(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range files {
+		fw, err := mw.CreateFormFile("file", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(f.content))
+	}
+	mw.Close()
+
+	req, err := http.NewRequest("POST", "/upload", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUploadHandlerMatchesIBAN(t *testing.T) {
+	var requestedAccounts []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		switch {
+		case r.URL.Path == "/accounts":
+			w.Write([]byte(`{"data": [
+				{"id": "1", "attributes": {"name": "Checking", "type": "asset", "iban": "NL91 ABNA 0417 1643 00"}},
+				{"id": "2", "attributes": {"name": "Savings", "type": "asset", "iban": ""}}
+			]}`))
+		case strings.HasSuffix(r.URL.Path, "/transactions"):
+			requestedAccounts = append(requestedAccounts, r.URL.Path)
+			w.Write([]byte(`{"data": []}`))
+		default:
+			w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+		}
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	camt := `<Document><BkToCstmrStmt><Stmt>
+		<Acct><Id><IBAN>NL91ABNA0417164300</IBAN></Id></Acct>
+		<Ntry><Amt Ccy="EUR">9.99</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2023-10-01</Dt></BookgDt>
+		<AddtlNtryInf>Music subscription</AddtlNtryInf></Ntry>
+	</Stmt></BkToCstmrStmt></Document>`
+
+	req := newUploadRequest(t, map[string]string{"account_id": "2"}, "statement.xml", camt)
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+
+	if len(requestedAccounts) != 1 || requestedAccounts[0] != "/accounts/1/transactions" {
+		t.Errorf("Expected deduplication against IBAN-matched account 1, got %v", requestedAccounts)
+	}
+	if !strings.Contains(rr.Body.String(), "source_id&#34;:&#34;1&#34;") {
+		t.Errorf("Expected transaction to be assigned to account 1, got %v", rr.Body.String())
+	}
+}
+
+func TestUploadHandlerReportsUnparsedRows(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	csvData := "Date,Description,Amount,Type\n2023-10-01,Groceries,45.50,withdrawal\n2023-10-02,Broken,abc,withdrawal\n"
+	req := newUploadRequest(t, map[string]string{"account_id": "1"}, "export.csv", csvData)
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+
+	body := rr.Body.String()
+	if !strings.Contains(body, "1 row(s) could not be parsed") {
+		t.Errorf("Expected a warning about the unparsed row, got %v", body)
+	}
+	if !strings.Contains(body, "status&#34;:&#34;Error&#34;") || !strings.Contains(body, "source_line&#34;:3") {
+		t.Errorf("Expected the unparsed row as an error row with its line number, got %v", body)
+	}
+}
+
+func TestUploadHandlerDetectsFormatFromContent(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	csvData := "Date,Description,Amount,Type\n2023-10-01,Groceries,45.50,withdrawal\n"
+	req := newUploadRequest(t, map[string]string{"account_id": "1"}, "export.txt", csvData)
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "Groceries") {
+		t.Errorf("Expected the CSV named .txt to be parsed, got %v", rr.Body.String())
+	}
+
+	req = newUploadRequest(t, map[string]string{"account_id": "1"}, "notes.bin", "\x00\x01\x02")
+	rr = httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code for unknown content: got %v want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestUploadHandlerMergesOverlappingScreenshots(t *testing.T) {
+	// Each screenshot is read by the Vision API; the second overlaps the first by one transaction
+	screenshots := map[string]string{
+		"shot-1": `[{"date":"2023-10-01","description":"Coffee","amount":3.50,"type":"withdrawal"},` +
+			`{"date":"2023-10-02","description":"Bakery","amount":2.10,"type":"withdrawal"}]`,
+		"shot-2": `[{"date":"2023-10-02","description":"Bakery","amount":2.10,"type":"withdrawal"},` +
+			`{"date":"2023-10-03","description":"Cinema","amount":12.00,"type":"withdrawal"}]`,
+	}
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/chat/completions" {
+			var req struct {
+				Messages []struct {
+					Content []struct {
+						ImageURL map[string]string `json:"image_url"`
+					} `json:"content"`
+				} `json:"messages"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			url := req.Messages[0].Content[1].ImageURL["url"]
+			image, _ := base64.StdEncoding.DecodeString(url[strings.Index(url, ",")+1:])
+
+			var content string
+			for marker, txs := range screenshots {
+				if bytes.Contains(image, []byte(marker)) {
+					content = txs
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"choices": []any{map[string]any{"message": map[string]string{"content": content}}},
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{VisionAPIURL: mockServer.URL}, nil)
+
+	req := newMultiUploadRequest(t, map[string]string{"account_id": "1"},
+		uploadFile{"IMG_0001.png", "\x89PNG\r\n\x1a\nshot-1"},
+		uploadFile{"IMG_0002.png", "\x89PNG\r\n\x1a\nshot-2"})
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+
+	body := rr.Body.String()
+	for _, description := range []string{"Coffee", "Cinema"} {
+		if !strings.Contains(body, description) {
+			t.Errorf("Expected %s from one of the screenshots, got %v", description, body)
+		}
+	}
+	if n := strings.Count(body, "Bakery&#34;"); n != 2 { // description and original_description
+		t.Errorf("Expected the overlapping transaction once, found it %d times", n/2)
+	}
+	if !strings.Contains(body, "Merged 1 transaction(s)") {
+		t.Errorf("Expected a warning about the merged transaction, got %v", body)
+	}
+}
+
+func TestUploadHandlerKeepsIdenticalStatementTransactions(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{}, nil)
+
+	// Two statements that each hold a real parking fee of the same amount on the same day
+	camt := `<Document><BkToCstmrStmt><Stmt>
+		<Ntry><Amt Ccy="EUR">2.50</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2023-10-01</Dt></BookgDt>
+		<AddtlNtryInf>Parking</AddtlNtryInf></Ntry>
+	</Stmt></BkToCstmrStmt></Document>`
+
+	req := newMultiUploadRequest(t, map[string]string{"account_id": "1"},
+		uploadFile{"first.xml", camt},
+		uploadFile{"second.xml", camt})
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v: %s", status, http.StatusOK, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if n := strings.Count(body, "Parking&#34;"); n != 4 { // description and original_description
+		t.Errorf("Expected both parking fees, found %d", n/2)
+	}
+	if strings.Contains(body, "Merged") {
+		t.Errorf("Expected statements not to be merged, got %v", body)
+	}
+}
+
+func TestUploadHandlerDedupeRange(t *testing.T) {
+	var start, end string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.api+json")
+		if strings.HasSuffix(r.URL.Path, "/transactions") {
+			start, end = r.URL.Query().Get("start"), r.URL.Query().Get("end")
+		}
+		w.Write([]byte(`{"data": [], "meta": {"pagination": {"total_pages": 1, "current_page": 1}}}`))
+	}))
+	defer mockServer.Close()
+
+	client := firefly.NewClient(mockServer.URL, "test-token")
+	appHandler := NewAppHandler(client, &config.Config{DedupeDateWindow: 2}, nil)
+
+	camt := `<Document><BkToCstmrStmt><Stmt>
+		<Ntry><Amt Ccy="EUR">9.99</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2023-10-01</Dt></BookgDt>
+		<AddtlNtryInf>Music subscription</AddtlNtryInf></Ntry>
+		<Ntry><Amt Ccy="EUR">50.00</Amt><CdtDbtInd>DBIT</CdtDbtInd><BookgDt><Dt>2023-12-20</Dt></BookgDt>
+		<AddtlNtryInf>Groceries</AddtlNtryInf></Ntry>
+	</Stmt></BkToCstmrStmt></Document>`
+
+	tests := []struct {
+		name       string
+		fields     map[string]string
+		start, end string
+	}{
+		{"statement dates", map[string]string{}, "2023-09-29", "2023-12-22"},
+		{"manual start", map[string]string{"dedupe_start": "2023-06-01"}, "2023-06-01", "2023-12-22"},
+		{"manual range", map[string]string{"dedupe_start": "2023-06-01", "dedupe_end": "2023-12-31"}, "2023-06-01", "2023-12-31"},
+	}
+	for _, tt := range tests {
+		tt.fields["account_id"] = "1"
+		rr := httptest.NewRecorder()
+		appHandler.UploadHandler(rr, newUploadRequest(t, tt.fields, "statement.xml", camt))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: handler returned wrong status code: got %v want %v: %s", tt.name, rr.Code, http.StatusOK, rr.Body.String())
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("%s: expected transactions from %s to %s, got %s to %s", tt.name, tt.start, tt.end, start, end)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	appHandler.UploadHandler(rr, newUploadRequest(t, map[string]string{
+		"account_id":   "1",
+		"dedupe_start": "2023-12-31",
+		"dedupe_end":   "2023-06-01",
+	}, "statement.xml", camt))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a range ending before it starts, got %d", rr.Code)
+	}
+}
@@ -2,9 +2,11 @@ package models
 
 // Account represents a Firefly III account.
 type Account struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	Type string `json:"type"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	IBAN     string `json:"iban,omitempty"`
+	Currency string `json:"currency_code,omitempty"`
 }
 
 // AccountResponse wrapper for the Firefly API JSON response
@@ -12,8 +14,10 @@ type AccountResponse struct {
 	Data []struct {
 		ID         string `json:"id"`
 		Attributes struct {
-			Name string `json:"name"`
-			Type string `json:"type"`
+			Name         string `json:"name"`
+			Type         string `json:"type"`
+			IBAN         string `json:"iban"`
+			CurrencyCode string `json:"currency_code"`
 		} `json:"attributes"`
 	} `json:"data"`
 }
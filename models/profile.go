@@ -0,0 +1,42 @@
+package models
+
+// Amount modes describe how a profile derives the transaction type from the amount columns.
+const (
+	// AmountModeAuto uses the type or debit/credit column when mapped and recognised, and the amount sign otherwise.
+	AmountModeAuto = ""
+	// AmountModeType reads the type from the type or debit/credit column, falling back to the sign when it is empty.
+	// Rows with a type it does not recognise are reported as errors.
+	AmountModeType = "type"
+	// AmountModeSigned treats negative amounts as withdrawals and positive amounts as deposits.
+	AmountModeSigned = "signed"
+	// AmountModeSplit reads separate debit and credit amount columns; whichever is filled decides the type.
+	AmountModeSplit = "split"
+)
+
+// ImportProfile describes how the columns of a bank export map onto a Transaction.
+// Column references are either a header name (matched case-insensitively) or a
+// zero-based column index.
+type ImportProfile struct {
+	Name               string `json:"name"`
+	HasHeader          bool   `json:"has_header"`
+	DateColumn         string `json:"date_column"`
+	DescriptionColumn  string `json:"description_column"`
+	AmountColumn       string `json:"amount_column"`
+	TypeColumn         string `json:"type_column,omitempty"`
+	DebitCreditColumn  string `json:"debit_credit_column,omitempty"`
+	CounterpartyColumn string `json:"counterparty_column,omitempty"`
+	CategoryColumn     string `json:"category_column,omitempty"`
+	BudgetColumn       string `json:"budget_column,omitempty"`
+	AmountMode         string `json:"amount_mode,omitempty"`
+	DebitAmountColumn  string `json:"debit_amount_column,omitempty"`
+	CreditAmountColumn string `json:"credit_amount_column,omitempty"`
+	InvertSign         bool   `json:"invert_sign,omitempty"`       // e.g. credit card exports listing charges as positive amounts
+	DateFormat         string `json:"date_format,omitempty"`       // e.g. "DD.MM.YYYY"; empty detects the format
+	DecimalSeparator   string `json:"decimal_separator,omitempty"` // "." or ","; empty detects it per value
+	Encoding           string `json:"encoding,omitempty"`          // e.g. "windows-1252"; empty detects the encoding
+	Delimiter          string `json:"delimiter,omitempty"`         // ",", ";", "tab" or "|"; empty sniffs it
+	Quote              string `json:"quote,omitempty"`             // `"` or "'"; empty sniffs it
+	Sheet              string `json:"sheet,omitempty"`             // Spreadsheet sheet name or 1-based index; empty uses the first sheet
+	HeaderRow          int    `json:"header_row,omitempty"`        // 1-based line of the header; 0 finds it
+	LinePattern        string `json:"line_pattern,omitempty"`      // Regexp with named groups matching transaction lines of PDF statements; empty uses a generic pattern
+}
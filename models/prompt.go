@@ -0,0 +1,18 @@
+package models
+
+// Prompt kinds tell which vision extraction a prompt template is written for.
+const (
+	// PromptKindStatement prompts read the transaction list of a bank statement screenshot.
+	PromptKindStatement = "statement"
+	// PromptKindReceipt prompts read the merchant, total and line items of a shop receipt.
+	PromptKindReceipt = "receipt"
+)
+
+// PromptTemplate is a named, versioned prompt for the Vision API. Text is a Go text/template
+// that can use {{.Today}}, {{.Year}}, {{.Account}}, {{.Currency}} and {{.Categories}}.
+type PromptTemplate struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Version int    `json:"version"` // Raised every time the text changes
+	Text    string `json:"text"`
+}
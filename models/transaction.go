@@ -4,10 +4,11 @@ package models
 type TransactionStatus string
 
 const (
-	StatusPending TransactionStatus = "Pending"
-	StatusAdded   TransactionStatus = "Added"
-	StatusSkipped TransactionStatus = "Skipped (Duplicate)"
-	StatusError   TransactionStatus = "Error"
+	StatusPending           TransactionStatus = "Pending"
+	StatusAdded             TransactionStatus = "Added"
+	StatusSkipped           TransactionStatus = "Skipped (Duplicate)"
+	StatusPossibleDuplicate TransactionStatus = "Possible duplicate" // Close to an existing transaction; imported only when confirmed
+	StatusError             TransactionStatus = "Error"
 )
 
 // Transaction represents a single financial transaction
@@ -26,5 +27,56 @@ type Transaction struct {
 	SuggestedBudget      string            `json:"suggested_budget,omitempty"`
 	CategoryName         string            `json:"category_name,omitempty"`
 	SuggestedCategory    string            `json:"suggested_category,omitempty"`
+	ExternalID           string            `json:"external_id,omitempty"` // Stable bank identifier, e.g. OFX FITID
+	ImportID             string            `json:"import_id,omitempty"`   // Deterministic ID stored in Firefly's external_id; see dedupe.AssignImportIDs
 	Status               TransactionStatus `json:"status,omitempty"`
+	AccountID            string            `json:"account_id,omitempty"`  // Firefly account the row is imported into
+	ParseError           string            `json:"parse_error,omitempty"` // Why the row could not be parsed; set with StatusError
+	SourceLine           int               `json:"source_line,omitempty"`
+	RawRecord            string            `json:"raw_record,omitempty"`
+	Extraction           *Extraction       `json:"extraction,omitempty"` // Set for transactions read by a vision model
+	Splits               []Split           `json:"splits,omitempty"`     // Parts of a split transaction; Description becomes the group title
+	JournalID            string            `json:"journal_id,omitempty"` // Set for transactions read from Firefly
+	GroupID              string            `json:"group_id,omitempty"`
+	Match                *Match            `json:"match,omitempty"` // Existing transaction a skipped or possible duplicate was matched with
+}
+
+// Split is one part of a split transaction, such as a line item of a receipt. The parts share
+// the date, type and accounts of their transaction and add up to its amount.
+type Split struct {
+	Description       string  `json:"description"`
+	Amount            float64 `json:"amount"` // Absolute value
+	BudgetName        string  `json:"budget_name,omitempty"`
+	CategoryName      string  `json:"category_name,omitempty"`
+	SuggestedCategory string  `json:"suggested_category,omitempty"`
+}
+
+// Match is the existing Firefly transaction an imported transaction was taken to duplicate.
+type Match struct {
+	JournalID   string  `json:"journal_id"`
+	GroupID     string  `json:"group_id"`
+	Date        string  `json:"date"`
+	Description string  `json:"description"`
+	Amount      float64 `json:"amount"`
+	ImportID    string  `json:"import_id,omitempty"`
+	URL         string  `json:"url,omitempty"` // Page of the transaction in the Firefly web interface
+}
+
+// Extraction describes how sure a vision model was of a transaction and where in the image it
+// read it.
+type Extraction struct {
+	Confidence    map[string]float64 `json:"confidence,omitempty"`     // 0 to 1 per field: date, description, amount and type
+	LowConfidence []string           `json:"low_confidence,omitempty"` // Fields whose confidence is too low to trust without review
+	Box           *Box               `json:"box,omitempty"`            // Region of the row in the uploaded image, when the model reports it
+	Crop          string             `json:"crop,omitempty"`           // Data URL of the image region around Box
+	PromptName    string             `json:"prompt_name,omitempty"`    // Prompt template the transaction was read with
+	PromptVersion int                `json:"prompt_version,omitempty"`
+}
+
+// Box is a rectangle in an image, in fractions of the image width and height from the top left.
+type Box struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
 }
@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"firefly-importer/models"
+)
+
+// camtDocument covers both camt.053 (BkToCstmrStmt) and camt.052 (BkToCstmrAcctRpt).
+// Tags carry no namespace so every camt.05x.001.xx version decodes the same way.
+type camtDocument struct {
+	Statements []camtStatement `xml:"BkToCstmrStmt>Stmt"`
+	Reports    []camtStatement `xml:"BkToCstmrAcctRpt>Rpt"`
+}
+
+type camtStatement struct {
+	Account struct {
+		IBAN     string `xml:"Id>IBAN"`
+		OtherID  string `xml:"Id>Othr>Id"`
+		Currency string `xml:"Ccy"`
+	} `xml:"Acct"`
+	Entries []camtEntry `xml:"Ntry"`
+}
+
+type camtAmount struct {
+	Value    string `xml:",chardata"`
+	Currency string `xml:"Ccy,attr"`
+}
+
+type camtDate struct {
+	Date     string `xml:"Dt"`
+	DateTime string `xml:"DtTm"`
+}
+
+func (d camtDate) value() string {
+	if d.Date != "" {
+		return d.Date
+	}
+	if len(d.DateTime) >= 10 {
+		return d.DateTime[:10]
+	}
+	return ""
+}
+
+type camtParty struct {
+	Name      string `xml:"Nm"`
+	PartyName string `xml:"Pty>Nm"` // camt.053.001.08 and later nest the name in Pty
+}
+
+func (p camtParty) name() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.PartyName
+}
+
+type camtEntry struct {
+	Amount         camtAmount          `xml:"Amt"`
+	CdtDbtInd      string              `xml:"CdtDbtInd"`
+	BookingDate    camtDate            `xml:"BookgDt"`
+	ValueDate      camtDate            `xml:"ValDt"`
+	AcctSvcrRef    string              `xml:"AcctSvcrRef"`
+	AdditionalInfo string              `xml:"AddtlNtryInf"`
+	Details        []camtTransactionDt `xml:"NtryDtls>TxDtls"`
+	Inner          string              `xml:",innerxml"`
+}
+
+// raw returns the entry's XML with indentation removed, for diagnostics.
+func (e camtEntry) raw() string {
+	var lines []string
+	for _, line := range strings.Split(e.Inner, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return "<Ntry>" + strings.Join(lines, "") + "</Ntry>"
+}
+
+type camtTransactionDt struct {
+	EndToEndID   string     `xml:"Refs>EndToEndId"`
+	AcctSvcrRef  string     `xml:"Refs>AcctSvcrRef"`
+	Amount       camtAmount `xml:"Amt"`
+	CdtDbtInd    string     `xml:"CdtDbtInd"`
+	Unstructured []string   `xml:"RmtInf>Ustrd"`
+	Debtor       camtParty  `xml:"RltdPties>Dbtr"`
+	Creditor     camtParty  `xml:"RltdPties>Cdtr"`
+}
+
+// ParseCAMT reads an ISO 20022 camt.053 statement or camt.052 report and returns one
+// Statement per account IBAN. Entries of multiple statements for the same IBAN are merged.
+// Entries without a usable date or amount are reported as diagnostics of their statement.
+func ParseCAMT(r io.Reader) ([]Statement, error) {
+	var doc camtDocument
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode camt xml: %w", err)
+	}
+
+	sources := append(doc.Statements, doc.Reports...)
+	if len(sources) == 0 {
+		return nil, errors.New("no camt statements or reports found")
+	}
+
+	var statements []Statement
+	byAccount := make(map[string]int)
+
+	for _, s := range sources {
+		iban := NormalizeIBAN(s.Account.IBAN)
+		if iban == "" {
+			iban = strings.TrimSpace(s.Account.OtherID)
+		}
+
+		idx, ok := byAccount[iban]
+		if !ok {
+			idx = len(statements)
+			byAccount[iban] = idx
+			statements = append(statements, Statement{IBAN: iban, Currency: s.Account.Currency})
+		}
+
+		for _, entry := range s.Entries {
+			txs, diags := entry.toTransactions()
+			statements[idx].Transactions = append(statements[idx].Transactions, txs...)
+			statements[idx].Diagnostics = append(statements[idx].Diagnostics, diags...)
+		}
+	}
+
+	return statements, nil
+}
+
+// NormalizeIBAN strips spaces and uppercases an IBAN so it can be compared.
+func NormalizeIBAN(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(iban), " ", ""))
+}
+
+// toTransactions maps an entry to transactions. Batch bookings whose details carry their own
+// amounts are split into one transaction per detail; otherwise the entry is a single transaction.
+func (e camtEntry) toTransactions() ([]models.Transaction, []Diagnostic) {
+	date := e.BookingDate.value()
+	if date == "" {
+		date = e.ValueDate.value()
+	}
+
+	var txs []models.Transaction
+	var diags []Diagnostic
+	add := func(tx models.Transaction, err error) {
+		if err != nil {
+			diags = append(diags, Diagnostic{Raw: e.raw(), Reason: err.Error(), Transaction: tx})
+			return
+		}
+		txs = append(txs, tx)
+	}
+
+	if len(e.Details) > 1 && e.detailsHaveAmounts() {
+		for _, d := range e.Details {
+			indicator := d.CdtDbtInd
+			if indicator == "" {
+				indicator = e.CdtDbtInd
+			}
+			add(camtTransaction(date, d.Amount.Value, indicator, e, d, true))
+		}
+		return txs, diags
+	}
+
+	var details camtTransactionDt
+	if len(e.Details) > 0 {
+		details = e.Details[0]
+	}
+	add(camtTransaction(date, e.Amount.Value, e.CdtDbtInd, e, details, false))
+	return txs, diags
+}
+
+// detailsHaveAmounts reports whether every transaction detail of a batch booking carries its own amount.
+func (e camtEntry) detailsHaveAmounts() bool {
+	for _, d := range e.Details {
+		if d.Amount.Value == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// camtTransaction maps an entry or one of its details onto a transaction. On error the
+// returned transaction holds the fields that could be read.
+func camtTransaction(date, amountStr, indicator string, e camtEntry, d camtTransactionDt, split bool) (models.Transaction, error) {
+	txType := "deposit"
+	counterparty := d.Debtor.name()
+	if strings.EqualFold(strings.TrimSpace(indicator), "DBIT") {
+		txType = "withdrawal"
+		counterparty = d.Creditor.name()
+	}
+	counterparty = strings.TrimSpace(counterparty)
+
+	description := strings.TrimSpace(strings.Join(d.Unstructured, " "))
+	if description == "" {
+		description = strings.TrimSpace(e.AdditionalInfo)
+	}
+	if description == "" {
+		description = counterparty
+	}
+
+	tx := models.Transaction{
+		Date:                date,
+		Description:         description,
+		OriginalDescription: description,
+		Type:                txType,
+		ExternalID:          camtReference(e, d, split),
+		Status:              models.StatusPending,
+	}
+	if txType == "withdrawal" {
+		tx.DestinationName = counterparty
+	} else {
+		tx.SourceName = counterparty
+	}
+
+	parsedDate, err := ParseDate(date, "YYYY-MM-DD")
+	if err != nil {
+		return tx, fmt.Errorf("booking date: %w", err)
+	}
+	tx.Date = parsedDate
+
+	amount, err := ParseAmount(amountStr, ".")
+	if err != nil {
+		return tx, err
+	}
+	if amount < 0 {
+		amount = -amount
+	}
+	tx.Amount = amount
+
+	return tx, nil
+}
+
+// camtReference picks the most specific bank reference available for an entry. Split batch
+// bookings share the entry reference, so they only use references of their own details.
+func camtReference(e camtEntry, d camtTransactionDt, split bool) string {
+	refs := []string{d.AcctSvcrRef, e.AcctSvcrRef, d.EndToEndID}
+	if split {
+		refs = []string{d.AcctSvcrRef, d.EndToEndID}
+	}
+	for _, ref := range refs {
+		ref = strings.TrimSpace(ref)
+		if ref != "" && !strings.EqualFold(ref, "NOTPROVIDED") {
+			return ref
+		}
+	}
+	return ""
+}
+
+func init() {
+	Register(camtParser{})
+}
+
+type camtParser struct{}
+
+func (camtParser) Name() string { return "camt.052/053" }
+
+func (camtParser) Detect(filename string, head []byte) int {
+	text := string(head)
+	if strings.Contains(text, "BkToCstmrStmt") || strings.Contains(text, "BkToCstmrAcctRpt") ||
+		strings.Contains(text, "urn:iso:std:iso:20022:tech:xsd:camt.05") {
+		return 100
+	}
+	if hasExtension(filename, ".xml") {
+		return 10
+	}
+	return 0
+}
+
+func (camtParser) Parse(r io.Reader, _ Options) ([]Statement, error) {
+	return ParseCAMT(r)
+}
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseCAMT053(t *testing.T) {
+	camtData := `<?xml version="1.0" encoding="UTF-8"?>
+<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">
+  <BkToCstmrStmt>
+    <Stmt>
+      <Acct><Id><IBAN>NL91 ABNA 0417 1643 00</IBAN></Id><Ccy>EUR</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="EUR">45.50</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2023-10-01</Dt></BookgDt>
+        <AcctSvcrRef>REF-001</AcctSvcrRef>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>NOTPROVIDED</EndToEndId></Refs>
+          <RltdPties><Cdtr><Nm>Albert Heijn</Nm></Cdtr></RltdPties>
+          <RmtInf><Ustrd>Groceries</Ustrd><Ustrd>week 40</Ustrd></RmtInf>
+        </TxDtls></NtryDtls>
+      </Ntry>
+    </Stmt>
+    <Stmt>
+      <Acct><Id><IBAN>DE89370400440532013000</IBAN></Id><Ccy>EUR</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="EUR">1500.00</Amt>
+        <CdtDbtInd>CRDT</CdtDbtInd>
+        <BookgDt><DtTm>2023-10-02T08:00:00</DtTm></BookgDt>
+        <NtryDtls><TxDtls>
+          <Refs><EndToEndId>SALARY-10</EndToEndId></Refs>
+          <RltdPties><Dbtr><Pty><Nm>ACME GmbH</Nm></Pty></Dbtr></RltdPties>
+        </TxDtls></NtryDtls>
+      </Ntry>
+    </Stmt>
+    <Stmt>
+      <Acct><Id><IBAN>NL91ABNA0417164300</IBAN></Id><Ccy>EUR</Ccy></Acct>
+      <Ntry>
+        <Amt Ccy="EUR">12.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2023-10-03</Dt></BookgDt>
+        <AddtlNtryInf>Bank fee</AddtlNtryInf>
+      </Ntry>
+      <Ntry>
+        <Amt Ccy="EUR"></Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2023-10-04</Dt></BookgDt>
+        <AddtlNtryInf>Missing amount</AddtlNtryInf>
+      </Ntry>
+    </Stmt>
+  </BkToCstmrStmt>
+</Document>`
+
+	statements, err := ParseCAMT(strings.NewReader(camtData))
+	if err != nil {
+		t.Fatalf("ParseCAMT failed: %v", err)
+	}
+
+	if len(statements) != 2 {
+		t.Fatalf("Expected 2 statements (one per IBAN), got %d", len(statements))
+	}
+
+	nl := statements[0]
+	if nl.IBAN != "NL91ABNA0417164300" {
+		t.Errorf("Expected normalized IBAN NL91ABNA0417164300, got %s", nl.IBAN)
+	}
+	if len(nl.Transactions) != 2 {
+		t.Fatalf("Expected 2 transactions for NL account, got %d", len(nl.Transactions))
+	}
+
+	tx := nl.Transactions[0]
+	if tx.Type != "withdrawal" {
+		t.Errorf("Expected Type withdrawal, got %s", tx.Type)
+	}
+	if tx.Description != "Groceries week 40" {
+		t.Errorf("Expected Description from RmtInf, got %s", tx.Description)
+	}
+	if tx.DestinationName != "Albert Heijn" {
+		t.Errorf("Expected DestinationName Albert Heijn, got %s", tx.DestinationName)
+	}
+	if tx.ExternalID != "REF-001" {
+		t.Errorf("Expected ExternalID REF-001, got %s", tx.ExternalID)
+	}
+	if nl.Transactions[1].Description != "Bank fee" {
+		t.Errorf("Expected Description from AddtlNtryInf, got %s", nl.Transactions[1].Description)
+	}
+	if len(nl.Diagnostics) != 1 || nl.Diagnostics[0].Transaction.Description != "Missing amount" {
+		t.Errorf("Expected a diagnostic for the entry without amount, got %+v", nl.Diagnostics)
+	}
+
+	de := statements[1].Transactions[0]
+	if de.Type != "deposit" {
+		t.Errorf("Expected Type deposit, got %s", de.Type)
+	}
+	if de.Date != "2023-10-02" {
+		t.Errorf("Expected Date 2023-10-02, got %s", de.Date)
+	}
+	if de.SourceName != "ACME GmbH" {
+		t.Errorf("Expected SourceName ACME GmbH, got %s", de.SourceName)
+	}
+	if de.Description != "ACME GmbH" {
+		t.Errorf("Expected Description to fall back to counterparty, got %s", de.Description)
+	}
+	if de.ExternalID != "SALARY-10" {
+		t.Errorf("Expected ExternalID SALARY-10, got %s", de.ExternalID)
+	}
+}
+
+func TestParseCAMT052(t *testing.T) {
+	camtData := `<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.052.001.06">
+  <BkToCstmrAcctRpt>
+    <Rpt>
+      <Acct><Id><IBAN>NL91ABNA0417164300</IBAN></Id></Acct>
+      <Ntry>
+        <Amt Ccy="EUR">30.00</Amt>
+        <CdtDbtInd>DBIT</CdtDbtInd>
+        <BookgDt><Dt>2023-10-05</Dt></BookgDt>
+        <NtryDtls>
+          <TxDtls><Refs><EndToEndId>A</EndToEndId></Refs><Amt Ccy="EUR">10.00</Amt><RmtInf><Ustrd>First</Ustrd></RmtInf></TxDtls>
+          <TxDtls><Refs><EndToEndId>B</EndToEndId></Refs><Amt Ccy="EUR">20.00</Amt><RmtInf><Ustrd>Second</Ustrd></RmtInf></TxDtls>
+        </NtryDtls>
+      </Ntry>
+    </Rpt>
+  </BkToCstmrAcctRpt>
+</Document>`
+
+	statements, err := ParseCAMT(strings.NewReader(camtData))
+	if err != nil {
+		t.Fatalf("ParseCAMT failed: %v", err)
+	}
+
+	if len(statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(statements))
+	}
+
+	txs := statements[0].Transactions
+	if len(txs) != 2 {
+		t.Fatalf("Expected batch booking to be split into 2 transactions, got %d", len(txs))
+	}
+	if txs[1].Amount != 20.00 || txs[1].Description != "Second" || txs[1].ExternalID != "B" {
+		t.Errorf("Unexpected second split transaction: %+v", txs[1])
+	}
+	if txs[1].Type != "withdrawal" {
+		t.Errorf("Expected split to inherit entry direction, got %s", txs[1].Type)
+	}
+}
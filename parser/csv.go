@@ -3,72 +3,506 @@ package parser
 import (
 	"encoding/csv"
 	"errors"
+	"fmt"
 	"io"
+	"math"
 	"strconv"
 	"strings"
-	"time"
 
 	"firefly-importer/models"
 )
 
-// ParseCSV reads a CSV from the provided io.Reader and maps it to a slice of models.Transaction
-// Assumes headers: Date, Description, Amount, Type
-func ParseCSV(r io.Reader) ([]models.Transaction, error) {
-	csvReader := csv.NewReader(r)
+// DefaultProfile matches the original fixed layout: Date, Description, Amount, Type.
+var DefaultProfile = models.ImportProfile{
+	HasHeader:         true,
+	DateColumn:        "0",
+	DescriptionColumn: "1",
+	AmountColumn:      "2",
+	TypeColumn:        "3",
+}
+
+// ValidateProfile checks that a profile maps every column required to build a transaction.
+func ValidateProfile(p models.ImportProfile) error {
+	if strings.TrimSpace(p.DateColumn) == "" {
+		return errors.New("profile must map a date column")
+	}
+	if strings.TrimSpace(p.DescriptionColumn) == "" {
+		return errors.New("profile must map a description column")
+	}
+
+	switch p.AmountMode {
+	case models.AmountModeSplit:
+		if strings.TrimSpace(p.DebitAmountColumn) == "" || strings.TrimSpace(p.CreditAmountColumn) == "" {
+			return errors.New("split amount mode requires both a debit and a credit amount column")
+		}
+	case models.AmountModeAuto, models.AmountModeType, models.AmountModeSigned:
+		if strings.TrimSpace(p.AmountColumn) == "" {
+			return errors.New("profile must map an amount column")
+		}
+		if p.AmountMode == models.AmountModeType && strings.TrimSpace(p.TypeColumn) == "" && strings.TrimSpace(p.DebitCreditColumn) == "" {
+			return errors.New("type amount mode requires a type or debit/credit column")
+		}
+	default:
+		return fmt.Errorf("unknown amount mode %q", p.AmountMode)
+	}
+
+	if p.DecimalSeparator != "" && p.DecimalSeparator != "." && p.DecimalSeparator != "," {
+		return fmt.Errorf("decimal separator must be \".\" or \",\", got %q", p.DecimalSeparator)
+	}
+	if _, ok := Encodings[strings.ToLower(p.Encoding)]; p.Encoding != "" && !ok {
+		return fmt.Errorf("unsupported encoding %q", p.Encoding)
+	}
+	if _, ok := Delimiters[p.Delimiter]; p.Delimiter != "" && !ok {
+		return fmt.Errorf("unsupported delimiter %q", p.Delimiter)
+	}
+	if p.Quote != "" && p.Quote != `"` && p.Quote != "'" {
+		return fmt.Errorf("quote must be a single or double quote, got %q", p.Quote)
+	}
+	if p.HeaderRow < 0 {
+		return fmt.Errorf("header row must be positive, got %d", p.HeaderRow)
+	}
+	if p.LinePattern != "" {
+		if _, err := compileLinePattern(p.LinePattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columnMap holds the resolved column indexes of a profile; -1 means unmapped.
+type columnMap struct {
+	date, description, amount, txType, debitCredit int
+	counterparty, category, budget                 int
+	debitAmount, creditAmount                      int
+}
+
+// maxIndex returns the highest mapped column index, used to detect short rows.
+func (c columnMap) maxIndex() int {
+	max := -1
+	for _, idx := range []int{c.date, c.description, c.amount, c.txType, c.debitCredit, c.counterparty, c.category, c.budget, c.debitAmount, c.creditAmount} {
+		if idx > max {
+			max = idx
+		}
+	}
+	return max
+}
+
+// resolveColumn turns a column reference into an index. An empty reference resolves to -1.
+func resolveColumn(ref string, header map[string]int) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return -1, nil
+	}
+	if idx, err := strconv.Atoi(ref); err == nil {
+		if idx < 0 {
+			return -1, fmt.Errorf("invalid column index %d", idx)
+		}
+		return idx, nil
+	}
+	if idx, ok := header[strings.ToLower(ref)]; ok {
+		return idx, nil
+	}
+	return -1, fmt.Errorf("column %q not found in header", ref)
+}
+
+func resolveColumns(p models.ImportProfile, header map[string]int) (columnMap, error) {
+	var c columnMap
+	refs := []struct {
+		ref string
+		dst *int
+	}{
+		{p.DateColumn, &c.date},
+		{p.DescriptionColumn, &c.description},
+		{p.AmountColumn, &c.amount},
+		{p.TypeColumn, &c.txType},
+		{p.DebitCreditColumn, &c.debitCredit},
+		{p.CounterpartyColumn, &c.counterparty},
+		{p.CategoryColumn, &c.category},
+		{p.BudgetColumn, &c.budget},
+		{p.DebitAmountColumn, &c.debitAmount},
+		{p.CreditAmountColumn, &c.creditAmount},
+	}
+	for _, r := range refs {
+		idx, err := resolveColumn(r.ref, header)
+		if err != nil {
+			return c, err
+		}
+		*r.dst = idx
+	}
+	return c, nil
+}
+
+// normalizeType maps common type and debit/credit markers onto Firefly transaction types.
+// It returns "" for values that do not say which way the money went, such as "Transfer".
+func normalizeType(v string) string {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "withdrawal", "debit", "d", "dr", "db", "af", "s", "soll",
+		"payment", "purchase", "pos", "atm", "fee", "charge", "out", "outgoing", "-":
+		return "withdrawal"
+	case "deposit", "credit", "c", "cr", "bij", "h", "haben",
+		"income", "refund", "interest", "salary", "in", "incoming", "+":
+		return "deposit"
+	}
+	return ""
+}
 
-	// Read header row
-	_, err := csvReader.Read()
+// field returns the trimmed value at idx, or an empty string when the column is unmapped.
+func field(record []string, idx int) string {
+	if idx < 0 || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
+
+// typeFromSign derives the transaction type from a signed amount. Profiles with InvertSign
+// treat positive amounts as withdrawals, as credit card exports commonly do.
+func typeFromSign(amount float64, invert bool) string {
+	withdrawal := amount < 0
+	if invert {
+		withdrawal = amount > 0
+	}
+	if withdrawal {
+		return "withdrawal"
+	}
+	return "deposit"
+}
+
+// parseOptionalAmount parses an amount cell, treating empty cells as zero.
+func parseOptionalAmount(v, decimalSep string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return ParseAmount(v, decimalSep)
+}
+
+// amountAndType resolves the absolute amount and transaction type of a record according to
+// the profile's amount mode.
+func (c columnMap) amountAndType(record []string, p models.ImportProfile) (float64, string, error) {
+	if p.AmountMode == models.AmountModeSplit {
+		debit, err := parseOptionalAmount(field(record, c.debitAmount), p.DecimalSeparator)
+		if err != nil {
+			return 0, "", fmt.Errorf("debit column: %w", err)
+		}
+		credit, err := parseOptionalAmount(field(record, c.creditAmount), p.DecimalSeparator)
+		if err != nil {
+			return 0, "", fmt.Errorf("credit column: %w", err)
+		}
+
+		var amount float64
+		var txType string
+		switch {
+		case debit != 0 && credit != 0:
+			return 0, "", errors.New("both debit and credit amount are filled")
+		case debit != 0:
+			amount, txType = math.Abs(debit), "withdrawal"
+		case credit != 0:
+			amount, txType = math.Abs(credit), "deposit"
+		default:
+			return 0, "", errors.New("neither debit nor credit amount is filled")
+		}
+		if p.InvertSign {
+			txType = invertType(txType)
+		}
+		return amount, txType, nil
+	}
+
+	amount, err := ParseAmount(field(record, c.amount), p.DecimalSeparator)
 	if err != nil {
-		if err == io.EOF {
-			return nil, errors.New("csv file is empty")
+		return 0, "", err
+	}
+
+	var typeValue string
+	if p.AmountMode != models.AmountModeSigned {
+		if c.debitCredit >= 0 {
+			typeValue = field(record, c.debitCredit)
+		} else {
+			typeValue = field(record, c.txType)
 		}
-		return nil, err
+	}
+
+	txType := normalizeType(typeValue)
+	if txType == "" {
+		if typeValue != "" && p.AmountMode == models.AmountModeType {
+			return 0, "", fmt.Errorf("unknown transaction type %q", typeValue)
+		}
+		// No usable type column: the sign of the amount decides
+		txType = typeFromSign(amount, p.InvertSign)
+	}
+
+	return math.Abs(amount), txType, nil
+}
+
+// invertType swaps withdrawals and deposits.
+func invertType(txType string) string {
+	if txType == "withdrawal" {
+		return "deposit"
+	}
+	return "withdrawal"
+}
+
+// sourceRow is a record together with the line or spreadsheet row it starts on.
+type sourceRow struct {
+	line   int
+	record []string
+}
+
+// headerSearchRows limits how far down a file the header row is looked for.
+const headerSearchRows = 20
+
+// findHeader returns the index of the header row: the row at the profile's HeaderRow, or else
+// the first row that contains every column the profile refers to by name. Bank exports often
+// put account details above the header.
+func findHeader(rows []sourceRow, profile models.ImportProfile) (int, error) {
+	if profile.HeaderRow > 0 {
+		for i, row := range rows {
+			if row.line >= profile.HeaderRow {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("header row %d not found", profile.HeaderRow)
+	}
+
+	for i := 0; i < len(rows) && i < headerSearchRows; i++ {
+		if _, err := resolveColumns(profile, headerIndex(rows[i].record)); err == nil {
+			return i, nil
+		}
+	}
+	// Report the missing column against the first row
+	_, err := resolveColumns(profile, headerIndex(rows[0].record))
+	return -1, err
+}
+
+// headerIndex maps lowercased header names to their column index.
+func headerIndex(record []string) map[string]int {
+	header := make(map[string]int)
+	for i, name := range record {
+		header[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	return header
+}
+
+// mapRows maps rows read from a CSV or spreadsheet to transactions using the column mapping
+// of the profile. Rows that cannot be mapped are returned as diagnostics, with their record
+// joined by delim.
+func mapRows(rows []sourceRow, profile models.ImportProfile, delim rune) ([]models.Transaction, []Diagnostic, error) {
+	header := make(map[string]int)
+	if profile.HasHeader {
+		if len(rows) == 0 {
+			return nil, nil, errors.New("file is empty")
+		}
+		idx, err := findHeader(rows, profile)
+		if err != nil {
+			return nil, nil, err
+		}
+		header = headerIndex(rows[idx].record)
+		rows = rows[idx+1:]
+	}
+
+	cols, err := resolveColumns(profile, header)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Without a configured format, pick the one layout that fits the most dates in the file so
+	// that ambiguous values like 05/10/2025 are read consistently
+	dateFormat := profile.DateFormat
+	if dateFormat == "" {
+		samples := make([]string, 0, len(rows))
+		for _, row := range rows {
+			samples = append(samples, field(row.record, cols.date))
+		}
+		dateFormat = DetectDateFormat(samples)
 	}
 
 	var transactions []models.Transaction
+	var diagnostics []Diagnostic
 
-	for {
-		record, err := csvReader.Read()
+	for _, row := range rows {
+		tx, err := cols.transaction(row.record, profile, dateFormat)
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:        row.line,
+				Raw:         joinRecord(row.record, delim),
+				Reason:      err.Error(),
+				Transaction: tx,
+			})
+			continue
 		}
+		transactions = append(transactions, tx)
+	}
+
+	return transactions, diagnostics, nil
+}
+
+// joinRecord re-encodes a record the way it appeared in the file, for diagnostics.
+func joinRecord(record []string, delim rune) string {
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Comma = delim
+	_ = w.Write(record)
+	w.Flush()
+	return strings.TrimRight(b.String(), "\r\n")
+}
 
-		if len(record) < 4 {
-			continue // Skip incomplete rows
+// isBlankRecord reports whether every field of a record is empty, as in trailing ",,," rows.
+func isBlankRecord(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
 		}
+	}
+	return true
+}
+
+// transaction maps a record onto a transaction. On error the returned transaction holds the
+// fields that could be read, with the raw date kept when it does not parse.
+func (c columnMap) transaction(record []string, profile models.ImportProfile, dateFormat string) (models.Transaction, error) {
+	description := field(record, c.description)
+	tx := models.Transaction{
+		Date:                field(record, c.date),
+		Description:         description,
+		OriginalDescription: description,
+		SuggestedCategory:   field(record, c.category),
+		SuggestedBudget:     field(record, c.budget),
+		Status:              models.StatusPending,
+	}
 
-		dateStr := strings.TrimSpace(record[0])
+	if maxIndex := c.maxIndex(); len(record) <= maxIndex {
+		return tx, fmt.Errorf("row has %d columns, the profile needs %d", len(record), maxIndex+1)
+	}
+
+	date, err := ParseDate(tx.Date, dateFormat)
+	if err != nil {
+		return tx, err
+	}
+	tx.Date = date
+
+	amount, txType, err := c.amountAndType(record, profile)
+	if err != nil {
+		return tx, err
+	}
+	tx.Amount = amount
+	tx.Type = txType
 
-		// Attempt simple YYYY-MM-DD validation
-		if _, err := time.Parse("2006-01-02", dateStr); err != nil {
-			continue // Skip rows with invalid date formats
+	if counterparty := field(record, c.counterparty); counterparty != "" {
+		if txType == "deposit" {
+			tx.SourceName = counterparty
+		} else {
+			tx.DestinationName = counterparty
 		}
+	}
 
-		description := strings.TrimSpace(record[1])
+	return tx, nil
+}
 
-		amountStr := strings.TrimSpace(record[2])
-		amount, err := strconv.ParseFloat(amountStr, 64)
+// ParseCSV reads a CSV from the provided io.Reader and maps it to a slice of models.Transaction
+// using the column mapping of the given profile. The encoding, delimiter and quote character
+// are detected unless the profile sets them, and lines above the header row are skipped. Rows
+// that cannot be mapped are returned as diagnostics instead of transactions.
+func ParseCSV(r io.Reader, profile models.ImportProfile) ([]models.Transaction, []Diagnostic, error) {
+	if err := ValidateProfile(profile); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read csv file: %w", err)
+	}
+	text, err := DecodeText(data, profile.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	quote := SniffQuote(text)
+	if profile.Quote != "" {
+		quote = rune(profile.Quote[0])
+	}
+	delim := Delimiters[profile.Delimiter]
+	if delim == 0 {
+		delim = SniffDelimiter(text, quote)
+	}
+	if delim == 0 {
+		delim = ','
+	}
+
+	singleQuoted := quote == '\''
+	if singleQuoted {
+		text = swapQuotes(text)
+	}
+
+	csvReader := csv.NewReader(strings.NewReader(text))
+	csvReader.Comma = delim
+	csvReader.FieldsPerRecord = -1 // short rows are reported below instead of failing the whole file
+	csvReader.LazyQuotes = true    // stray quotes inside unquoted fields are common in merchant names
+	read := func() ([]string, error) {
+		record, err := csvReader.Read()
+		if singleQuoted {
+			for i := range record {
+				record[i] = swapQuotes(record[i])
+			}
+		}
+		return record, err
+	}
+
+	var rows []sourceRow
+	for {
+		record, err := read()
 		if err != nil {
-			continue // Skip rows with invalid amounts
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, err
 		}
-		if amount < 0 {
-			amount = -amount // Ensure absolute value
+		if isBlankRecord(record) {
+			continue
 		}
+		line, _ := csvReader.FieldPos(0)
+		rows = append(rows, sourceRow{line: line, record: record})
+	}
+
+	if profile.HasHeader && len(rows) == 0 {
+		return nil, nil, errors.New("csv file is empty")
+	}
+	return mapRows(rows, profile, delim)
+}
+
+func init() {
+	Register(csvParser{})
+}
+
+// csvParser is the fallback for delimited text, such as bank exports saved as .txt.
+type csvParser struct{}
+
+func (csvParser) Name() string { return "CSV" }
 
-		txType := strings.ToLower(strings.TrimSpace(record[3]))
+func (csvParser) usesProfile() {}
+
+func (csvParser) Detect(filename string, head []byte) int {
+	if hasExtension(filename, ".csv") {
+		return 60
+	}
+	if looksDelimited(head) {
+		return 30
+	}
+	return 0
+}
+
+func (csvParser) Parse(r io.Reader, opts Options) ([]Statement, error) {
+	txs, diags, err := ParseCSV(r, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, diags), nil
+}
 
-		transactions = append(transactions, models.Transaction{
-			Date:                dateStr,
-			Description:         description,
-			OriginalDescription: description,
-			Amount:              amount,
-			Type:                txType,
-			Status:              models.StatusPending,
-		})
+// looksDelimited reports whether the first lines of a text file, in any supported encoding,
+// share a delimiter count.
+func looksDelimited(head []byte) bool {
+	text, err := DecodeText(head, "")
+	if err != nil || strings.ContainsRune(text, 0) {
+		return false // Binary content
 	}
 
-	return transactions, nil
+	_, consistent := sniffDelimiter(sniffLines(text), SniffQuote(text))
+	return consistent >= 2
 }
@@ -14,7 +14,7 @@ invalid,bad_row,amount,error`
 
 	r := strings.NewReader(csvData)
 
-	txs, err := ParseCSV(r)
+	txs, _, err := ParseCSV(r, DefaultProfile)
 	if err != nil {
 		t.Fatalf("ParseCSV failed: %v", err)
 	}
@@ -51,3 +51,285 @@ invalid,bad_row,amount,error`
 		t.Errorf("Expected Type deposit, got %s", txs[1].Type)
 	}
 }
+
+func TestParseCSVWithProfile(t *testing.T) {
+	csvData := `Booking Date,Payee,Memo,Amount,Af Bij,Category
+2023-10-01,Albert Heijn,Groceries,45.50,Af,Food
+2023-10-02,Employer BV,Salary,1500.00,Bij,`
+
+	profile := models.ImportProfile{
+		Name:               "ING",
+		HasHeader:          true,
+		DateColumn:         "booking date",
+		DescriptionColumn:  "Memo",
+		AmountColumn:       "3",
+		DebitCreditColumn:  "Af Bij",
+		CounterpartyColumn: "Payee",
+		CategoryColumn:     "Category",
+	}
+
+	r := strings.NewReader(csvData)
+
+	txs, _, err := ParseCSV(r, profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+
+	if txs[0].Description != "Groceries" {
+		t.Errorf("Expected Description Groceries, got %s", txs[0].Description)
+	}
+	if txs[0].Type != "withdrawal" {
+		t.Errorf("Expected Type withdrawal, got %s", txs[0].Type)
+	}
+	if txs[0].DestinationName != "Albert Heijn" {
+		t.Errorf("Expected DestinationName Albert Heijn, got %s", txs[0].DestinationName)
+	}
+	if txs[0].SuggestedCategory != "Food" {
+		t.Errorf("Expected SuggestedCategory Food, got %s", txs[0].SuggestedCategory)
+	}
+	if txs[1].Type != "deposit" {
+		t.Errorf("Expected Type deposit, got %s", txs[1].Type)
+	}
+	if txs[1].SourceName != "Employer BV" {
+		t.Errorf("Expected SourceName Employer BV, got %s", txs[1].SourceName)
+	}
+}
+
+func TestParseCSVUnknownColumn(t *testing.T) {
+	profile := DefaultProfile
+	profile.DateColumn = "Valuta"
+
+	_, _, err := ParseCSV(strings.NewReader("Date,Description,Amount,Type\n"), profile)
+	if err == nil {
+		t.Fatal("Expected error for unknown column, got nil")
+	}
+}
+
+func TestParseCSVSignedAmounts(t *testing.T) {
+	csvData := `Date,Description,Amount,Type
+2023-10-01,Groceries,-45.50,
+2023-10-02,Refund,12.00,`
+
+	txs, _, err := ParseCSV(strings.NewReader(csvData), DefaultProfile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+
+	// An empty type column falls back to the sign of the amount
+	if txs[0].Type != "withdrawal" || txs[0].Amount != 45.50 {
+		t.Errorf("Expected withdrawal of 45.50, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+	if txs[1].Type != "deposit" || txs[1].Amount != 12.00 {
+		t.Errorf("Expected deposit of 12.00, got %s of %f", txs[1].Type, txs[1].Amount)
+	}
+
+	// Credit card statements list charges as positive amounts
+	profile := models.ImportProfile{
+		HasHeader:         true,
+		DateColumn:        "Date",
+		DescriptionColumn: "Description",
+		AmountColumn:      "Amount",
+		AmountMode:        models.AmountModeSigned,
+		InvertSign:        true,
+	}
+
+	txs, _, err = ParseCSV(strings.NewReader(csvData), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+
+	if txs[0].Type != "deposit" {
+		t.Errorf("Expected inverted negative amount to be a deposit, got %s", txs[0].Type)
+	}
+	if txs[1].Type != "withdrawal" {
+		t.Errorf("Expected inverted positive amount to be a withdrawal, got %s", txs[1].Type)
+	}
+}
+
+func TestParseCSVTypeValues(t *testing.T) {
+	csvData := `Date,Description,Amount,Type
+2023-10-01,Card payment,-12.00,POS
+2023-10-02,To savings,-100.00,Transfer
+2023-10-03,From savings,50.00,Transfer`
+
+	// Auto mode falls back to the sign for types it does not know
+	txs, diags, err := ParseCSV(strings.NewReader(csvData), DefaultProfile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(txs) != 3 || len(diags) != 0 {
+		t.Fatalf("Expected 3 transactions, got %d and %d diagnostics", len(txs), len(diags))
+	}
+	for i, want := range []string{"withdrawal", "withdrawal", "deposit"} {
+		if txs[i].Type != want {
+			t.Errorf("Expected %s to be a %s, got %q", txs[i].Description, want, txs[i].Type)
+		}
+	}
+
+	// Type mode reports them instead of guessing
+	profile := DefaultProfile
+	profile.AmountMode = models.AmountModeType
+	txs, diags, err = ParseCSV(strings.NewReader(csvData), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(txs) != 1 || len(diags) != 2 || !strings.Contains(diags[0].Reason, `"Transfer"`) {
+		t.Errorf("Expected the transfers as diagnostics, got %+v and %+v", txs, diags)
+	}
+}
+
+func TestParseCSVSplitDebitCredit(t *testing.T) {
+	csvData := `Date,Description,Debit,Credit
+2023-10-01,Groceries,45.50,
+2023-10-02,Salary,,1500.00
+2023-10-03,Ambiguous,1.00,2.00
+2023-10-04,Empty,,`
+
+	profile := models.ImportProfile{
+		HasHeader:          true,
+		DateColumn:         "Date",
+		DescriptionColumn:  "Description",
+		AmountMode:         models.AmountModeSplit,
+		DebitAmountColumn:  "Debit",
+		CreditAmountColumn: "Credit",
+	}
+
+	txs, _, err := ParseCSV(strings.NewReader(csvData), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+	if txs[0].Type != "withdrawal" || txs[0].Amount != 45.50 {
+		t.Errorf("Expected withdrawal of 45.50, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+	if txs[1].Type != "deposit" || txs[1].Amount != 1500.00 {
+		t.Errorf("Expected deposit of 1500.00, got %s of %f", txs[1].Type, txs[1].Amount)
+	}
+}
+
+func TestParseCSVLocaleFormats(t *testing.T) {
+	csvData := `Datum,Omschrijving,Bedrag
+05.10.2025,Supermarkt,"-1.234,56"
+31.12.2025,Salaris,"2.500,00 EUR"`
+
+	profile := models.ImportProfile{
+		HasHeader:         true,
+		DateColumn:        "Datum",
+		DescriptionColumn: "Omschrijving",
+		AmountColumn:      "Bedrag",
+		AmountMode:        models.AmountModeSigned,
+	}
+
+	// Detected: the 31st disambiguates the day-first date format
+	txs, _, err := ParseCSV(strings.NewReader(csvData), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+	if txs[0].Date != "2025-10-05" {
+		t.Errorf("Expected date 2025-10-05, got %s", txs[0].Date)
+	}
+	if txs[0].Type != "withdrawal" || txs[0].Amount != 1234.56 {
+		t.Errorf("Expected withdrawal of 1234.56, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+	if txs[1].Amount != 2500.00 {
+		t.Errorf("Expected amount 2500.00, got %f", txs[1].Amount)
+	}
+
+	// Configured: a month-first format reads the first date differently and rejects the second
+	profile.DateFormat = "MM.DD.YYYY"
+	profile.DecimalSeparator = ","
+	txs, _, err = ParseCSV(strings.NewReader(csvData), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Date != "2025-05-10" {
+		t.Errorf("Expected a single transaction dated 2025-05-10, got %+v", txs)
+	}
+}
+
+func TestValidateProfileDecimalSeparator(t *testing.T) {
+	profile := DefaultProfile
+	profile.DecimalSeparator = ";"
+	if err := ValidateProfile(profile); err == nil {
+		t.Error("Expected an error for an invalid decimal separator")
+	}
+}
+
+func TestParseCSVDiagnostics(t *testing.T) {
+	csvData := `Date,Description,Amount,Type
+2023-10-01,Groceries,45.50,withdrawal
+2023-13-45,Bad date,10.00,withdrawal
+2023-10-03,"Bad, amount",ten,withdrawal
+2023-10-04,Short row
+,,,
+2023-10-05,Rent,800.00,withdrawal`
+
+	txs, diags, err := ParseCSV(strings.NewReader(csvData), DefaultProfile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Errorf("Expected 2 transactions, got %d", len(txs))
+	}
+	if len(diags) != 3 {
+		t.Fatalf("Expected 3 diagnostics, got %d", len(diags))
+	}
+
+	if diags[0].Line != 3 || diags[0].Transaction.Date != "2023-13-45" || diags[0].Transaction.Description != "Bad date" {
+		t.Errorf("Unexpected date diagnostic: %+v", diags[0])
+	}
+	if diags[1].Line != 4 || diags[1].Raw != `2023-10-03,"Bad, amount",ten,withdrawal` {
+		t.Errorf("Unexpected amount diagnostic: %+v", diags[1])
+	}
+	if diags[2].Line != 5 || !strings.Contains(diags[2].Reason, "columns") {
+		t.Errorf("Unexpected short row diagnostic: %+v", diags[2])
+	}
+}
+
+func TestParseCSVEncodingAndDialect(t *testing.T) {
+	// Windows-1252 with semicolons, single quotes and a decimal comma
+	data := "'Datum';'Omschrijving';'Bedrag'\r\n'01.10.2023';'B\xE4ckerei M\xFCller; Filiale 2';'-3,50'\r\n"
+
+	profile := models.ImportProfile{
+		HasHeader:         true,
+		DateColumn:        "Datum",
+		DescriptionColumn: "Omschrijving",
+		AmountColumn:      "Bedrag",
+		AmountMode:        models.AmountModeSigned,
+	}
+
+	txs, diags, err := ParseCSV(strings.NewReader(data), profile)
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(txs) != 1 {
+		t.Fatalf("Expected 1 transaction, got %d (diagnostics: %+v)", len(txs), diags)
+	}
+	if txs[0].Description != "Bäckerei Müller; Filiale 2" {
+		t.Errorf("Expected transcoded description, got %q", txs[0].Description)
+	}
+	if txs[0].Amount != 3.50 || txs[0].Type != "withdrawal" {
+		t.Errorf("Expected withdrawal of 3.50, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+
+	// A forced delimiter that does not match leaves a single column per row
+	profile.Delimiter = "tab"
+	if _, _, err := ParseCSV(strings.NewReader(data), profile); err == nil {
+		t.Error("Expected an error for header columns that do not exist with a forced tab delimiter")
+	}
+}
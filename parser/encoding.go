@@ -0,0 +1,208 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Encodings lists the character encodings a profile can force, by name.
+var Encodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"windows-1252": charmap.Windows1252,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-15":  charmap.ISO8859_15,
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// DetectEncoding guesses the encoding of a text file from its byte order mark, the position
+// of NUL bytes, or UTF-8 validity. Text that is not valid UTF-8 is assumed to be
+// Windows-1252, a superset of the printable ISO-8859-1 range most bank exports use.
+func DetectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return "utf-8"
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return "utf-16le"
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return "utf-16be"
+	}
+
+	// UTF-16 without a BOM: ASCII characters leave every other byte zero
+	if len(data) >= 4 {
+		evenNUL, oddNUL := 0, 0
+		for i, b := range data {
+			if b == 0 {
+				if i%2 == 0 {
+					evenNUL++
+				} else {
+					oddNUL++
+				}
+			}
+		}
+		half := len(data) / 4
+		if oddNUL > half && evenNUL == 0 {
+			return "utf-16le"
+		}
+		if evenNUL > half && oddNUL == 0 {
+			return "utf-16be"
+		}
+	}
+
+	if utf8.Valid(data) || utf8.Valid(trimIncompleteRune(data)) {
+		return "utf-8"
+	}
+	return "windows-1252"
+}
+
+// trimIncompleteRune drops a multi-byte character cut off at the end of a file head.
+func trimIncompleteRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			return data[:len(data)-i]
+		}
+	}
+	return data
+}
+
+// DecodeText transcodes data to UTF-8 and strips a byte order mark. An empty name detects
+// the encoding; otherwise it must be a key of Encodings.
+func DecodeText(data []byte, name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = DetectEncoding(data)
+	}
+	enc, ok := Encodings[name]
+	if !ok {
+		return "", fmt.Errorf("unsupported encoding %q", name)
+	}
+
+	switch name {
+	case "utf-8":
+		data = bytes.TrimPrefix(data, bomUTF8)
+	case "utf-16le":
+		data = bytes.TrimPrefix(data, bomUTF16LE)
+	case "utf-16be":
+		data = bytes.TrimPrefix(data, bomUTF16BE)
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s text: %w", name, err)
+	}
+	return string(decoded), nil
+}
+
+// Delimiters are the field separators a profile can force. "tab" stands for a tab character.
+var Delimiters = map[string]rune{
+	",":   ',',
+	";":   ';',
+	"tab": '\t',
+	"|":   '|',
+}
+
+// delimiterCandidates are tried in order when sniffing, so a tie goes to the comma.
+var delimiterCandidates = []rune{',', ';', '\t', '|'}
+
+// singleQuotedField matches a field wrapped in single quotes, as some banks export.
+var singleQuotedField = regexp.MustCompile(`(^|[,;\t|])'[^']*'([,;\t|]|$)`)
+
+// sniffLines returns up to ten non-empty lines from the start of a text.
+func sniffLines(text string) []string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+			if len(lines) == 10 {
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// SniffQuote returns the quote character of a delimited text: a single quote when fields
+// are wrapped in single quotes and no double quotes occur, a double quote otherwise.
+func SniffQuote(text string) rune {
+	for _, line := range sniffLines(text) {
+		if strings.ContainsRune(line, '"') {
+			return '"'
+		}
+		if singleQuotedField.MatchString(line) {
+			return '\''
+		}
+	}
+	return '"'
+}
+
+// countOutsideQuotes counts delim in line, ignoring quoted sections.
+func countOutsideQuotes(line string, delim, quote rune) int {
+	count, quoted := 0, false
+	for _, r := range line {
+		switch {
+		case r == quote:
+			quoted = !quoted
+		case r == delim && !quoted:
+			count++
+		}
+	}
+	return count
+}
+
+// SniffDelimiter picks the delimiter that splits the first lines of a text into the same
+// number of fields most consistently, preferring more fields on a tie. Lines are compared
+// against the most common non-zero count rather than the first line, so that a preamble of
+// account details above the header does not decide the delimiter. It returns 0 when no
+// candidate occurs in the first lines.
+func SniffDelimiter(text string, quote rune) rune {
+	delim, _ := sniffDelimiter(sniffLines(text), quote)
+	return delim
+}
+
+// sniffDelimiter returns the delimiter SniffDelimiter picks for lines, together with the number
+// of lines it splits into the same number of fields.
+func sniffDelimiter(lines []string, quote rune) (rune, int) {
+	var best rune
+	bestConsistent, bestCount := 0, 0
+	for _, delim := range delimiterCandidates {
+		lineCounts := map[int]int{} // Delimiters in a line -> lines with that many
+		for _, line := range lines {
+			if count := countOutsideQuotes(line, delim, quote); count > 0 {
+				lineCounts[count]++
+			}
+		}
+		for count, consistent := range lineCounts {
+			if consistent > bestConsistent || (consistent == bestConsistent && count > bestCount) {
+				best, bestConsistent, bestCount = delim, consistent, count
+			}
+		}
+	}
+	return best, bestConsistent
+}
+
+// swapQuotes exchanges single and double quotes. encoding/csv only understands double quotes,
+// so single-quoted text is swapped before reading and every field is swapped back afterwards.
+func swapQuotes(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '"':
+			return '\''
+		case '\'':
+			return '"'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,78 @@
+package parser
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+func encodeUTF16LE(s string, bom bool) []byte {
+	var b []byte
+	if bom {
+		b = append(b, 0xFF, 0xFE)
+	}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		encoding string
+		want     string
+	}{
+		{"utf-8 with BOM", []byte("\xEF\xBB\xBFM\xC3\xBCller"), "", "Müller"},
+		{"windows-1252", []byte("M\xFCller \x80"), "", "Müller €"},
+		{"utf-16 with BOM", encodeUTF16LE("Müller", true), "", "Müller"},
+		{"utf-16 without BOM", encodeUTF16LE("Date;Amount", false), "", "Date;Amount"},
+		{"forced iso-8859-1", []byte("Caf\xE9"), "iso-8859-1", "Café"},
+	}
+
+	for _, tt := range tests {
+		got, err := DecodeText(tt.data, tt.encoding)
+		if err != nil {
+			t.Errorf("%s: DecodeText failed: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := DecodeText([]byte("x"), "ebcdic"); err == nil {
+		t.Error("Expected an error for an unsupported encoding")
+	}
+}
+
+func TestSniffDelimiter(t *testing.T) {
+	tests := []struct {
+		text string
+		want rune
+	}{
+		{"Date,Description,Amount\n2023-10-01,Groceries,-45.50\n", ','},
+		{"Datum;Omschrijving;Bedrag\n01-10-2023;Albert Heijn;-1,50\n01-10-2023;Salaris;2.500,00\n", ';'},
+		{"Date\tDescription\tAmount\n2023-10-01\tGroceries, fresh\t-45.50\n", '\t'},
+		{"Date;Description\n2023-10-01;\"Shop; Ltd\"\n", ';'},
+		{"no delimiters here\n", 0},
+		// Account details above the header, one of them with a comma
+		{"Kontonummer: 123456789\nZeitraum: 01.10.2023 - 31.10.2023, alle Umsätze\n\nDatum;Text;Betrag\n01.10.2023;REWE;-1,50\n02.10.2023;Gehalt;2.500,00\n", ';'},
+		{"Account, Checking\nDate|Description|Amount\n2023-10-01|Groceries|-45.50\n", '|'},
+	}
+
+	for _, tt := range tests {
+		if got := SniffDelimiter(tt.text, SniffQuote(tt.text)); got != tt.want {
+			t.Errorf("SniffDelimiter(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSniffQuote(t *testing.T) {
+	if q := SniffQuote("'Date';'Amount'\n'2023-10-01';'1,50'\n"); q != '\'' {
+		t.Errorf("Expected single quote, got %q", q)
+	}
+	if q := SniffQuote("Date,Description\n2023-10-01,\"Kiosk 'Zur Post'\"\n"); q != '"' {
+		t.Errorf("Expected double quote, got %q", q)
+	}
+}
@@ -2,49 +2,87 @@ package parser
 
 import (
 	"bytes"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"io"
-	"net/http"
+	"log"
 	"strings"
 	"time"
 
+	"firefly-importer/dedupe"
 	"firefly-importer/models"
 )
 
-type visionRequest struct {
-	Model    string        `json:"model"`
-	Messages []interface{} `json:"messages"`
+// VisionCache stores the transactions extracted from an image, so that uploading the same image
+// again does not call the Vision API.
+type VisionCache interface {
+	// GetVisionResult returns the cached transactions for a key, or false when there are none.
+	GetVisionResult(key string) ([]models.Transaction, bool, error)
+	// SaveVisionResult stores the transactions extracted for a key.
+	SaveVisionResult(key string, transactions []models.Transaction) error
 }
 
-type textMessage struct {
-	Role    string        `json:"role"`
-	Content []interface{} `json:"content"`
-}
+// VisionCacheKey identifies the result of reading an image with the provider, model and image
+// limits of cfg and a version of a prompt template, rendered as prompt. The rendered text
+// covers the file date, account, currency and categories the template was filled in with.
+func VisionCacheKey(image []byte, cfg VisionConfig, promptTemplate models.PromptTemplate, prompt string) string {
+	provider := cfg.Provider
+	if provider == "" {
+		provider = VisionProviderOpenAI
+	}
+	maxDimension, maxBytes := visionLimits(cfg)
 
-type textContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	h := sha256.New()
+	h.Write(image)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%d\x00%d\x00%g\x00%g\x00%g", provider, cfg.Model,
+		maxDimension, maxBytes, tileAspectLimit, tileAspect, tileOverlap)
+	fmt.Fprintf(h, "\x00%s\x00%s\x00%d\x00%s", promptTemplate.Kind, promptTemplate.Name, promptTemplate.Version, prompt)
+	return hex.EncodeToString(h.Sum(nil))
 }
 
-type imageContent struct {
-	Type     string            `json:"type"`
-	ImageURL map[string]string `json:"image_url"`
+// cachedVisionResult returns the cached transactions for a key, unless caching is disabled or
+// a fresh extraction was asked for.
+func cachedVisionResult(cfg VisionConfig, key string) ([]models.Transaction, bool) {
+	if cfg.Cache == nil || cfg.Refresh {
+		return nil, false
+	}
+	transactions, ok, err := cfg.Cache.GetVisionResult(key)
+	if err != nil {
+		log.Printf("Failed to read cached vision result (ignoring): %v", err)
+		return nil, false
+	}
+	return transactions, ok
 }
 
-type visionResponse struct {
-	Choices []struct {
-		Message struct {
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+// saveVisionResult caches the transactions read for a key.
+func saveVisionResult(cfg VisionConfig, key string, transactions []models.Transaction) {
+	if cfg.Cache == nil {
+		return
+	}
+	if err := cfg.Cache.SaveVisionResult(key, transactions); err != nil {
+		log.Printf("Failed to cache vision result (ignoring): %v", err)
+	}
 }
 
-// ParseImage sends an image to a Vision API and extracts transaction data.
+// ParseImage sends an image to a Vision API and extracts transaction data, using the default
+// image size limits.
 func ParseImage(r io.Reader, fileDate, visionAPIURL, visionAPIKey, visionModel string) ([]models.Transaction, error) {
-	if visionAPIURL == "" {
+	return ParseImageWithConfig(r, fileDate, VisionConfig{APIURL: visionAPIURL, APIKey: visionAPIKey, Model: visionModel})
+}
+
+// ParseImageWithConfig sends an image to a Vision API and extracts transaction data. Large
+// images are downscaled and very tall ones are read as overlapping tiles whose transactions are
+// merged. The request goes through the configured VisionProvider and asks for structured
+// output matching a JSON schema; endpoints without support get a plain prompt, and JSON wrapped
+// in markdown or prose is still accepted. An answer that cannot be used is sent back to the
+// model once with the reason before giving up. Results are kept in cfg.Cache, which is
+// consulted first unless cfg.Refresh is set.
+func ParseImageWithConfig(r io.Reader, fileDate string, cfg VisionConfig) ([]models.Transaction, error) {
+	if cfg.APIURL == "" {
 		return nil, errors.New("vision API URL is required")
 	}
 
@@ -54,94 +92,291 @@ func ParseImage(r io.Reader, fileDate, visionAPIURL, visionAPIKey, visionModel s
 		return nil, fmt.Errorf("failed to read image: %w", err)
 	}
 
-	base64Image := base64.StdEncoding.EncodeToString(imageBytes)
-
-	currentDate := fileDate
-	if currentDate == "" {
-		currentDate = time.Now().Format("2006-01-02")
-	}
-	currentYear := ""
-	if len(currentDate) >= 4 {
-		currentYear = currentDate[:4]
-	} else {
-		currentYear = time.Now().Format("2006")
-	}
-
-	// Construct OpenAI-compatible payload
-	prompt := `Extract bank transactions from this image. Return ONLY a JSON array with objects containing:
-	"date" (YYYY-MM-DD), "description" (string), "amount" (float, absolute value), and "type" (string: "withdrawal" or "deposit").
-	Description should only contain transaction title, not the full transaction details.
-	Assume the year is ` + currentYear + ` if not provided in the image.
-	Today's date is ` + currentDate + `, use this to resolve relative dates like "today" or "yesterday".
-	Do not include markdown blocks like ` + "```json" + `, ` + "```" + `, or any other text.`
-
-	payload := visionRequest{
-		Model: visionModel,
-		Messages: []interface{}{
-			textMessage{
-				Role: "user",
-				Content: []interface{}{
-					textContent{Type: "text", Text: prompt},
-					imageContent{
-						Type: "image_url",
-						ImageURL: map[string]string{
-							"url": fmt.Sprintf("data:image/jpeg;base64,%s", base64Image),
-						},
-					},
-				},
-			},
-		},
-	}
-
-	payloadBytes, err := json.Marshal(payload)
+	promptTemplate := promptFor(cfg, models.PromptKindStatement)
+	prompt, err := RenderPrompt(promptTemplate, promptData(fileDate, cfg))
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode vision payload: %w", err)
+		return nil, err
+	}
+	cacheKey := VisionCacheKey(imageBytes, cfg, promptTemplate, prompt)
+	if transactions, ok := cachedVisionResult(cfg, cacheKey); ok {
+		return transactions, nil
 	}
 
-	endpoint := strings.TrimRight(visionAPIURL, "/") + "/v1/chat/completions"
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	images, err := prepareVisionImages(imageBytes, cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create vision request: %w", err)
+		return nil, err
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if visionAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+visionAPIKey)
+	if len(images) > 1 {
+		prompt += "\nThe image is one part of a long screenshot; ignore a transaction cut off at the top or bottom edge."
 	}
 
-	client := http.DefaultClient
-	resp, err := client.Do(req)
+	provider, err := NewVisionProvider(cfg.Provider, cfg.APIURL, cfg.APIKey)
 	if err != nil {
-		return nil, fmt.Errorf("vision API request failed: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("vision API returned non-200 status %d: %s", resp.StatusCode, string(bodyBytes))
+	batches := make([][]models.Transaction, 0, len(images))
+	for _, tile := range images {
+		txs, err := extractTransactions(provider, cfg.Model, prompt, tile.VisionImage)
+		if err != nil {
+			return nil, err
+		}
+		locateRows(txs, tile)
+		batches = append(batches, txs)
 	}
+	// Tiles overlap, so rows near the edges are read twice
+	transactions := dedupe.MergeOverlaps(batches)
 
-	var vResp visionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&vResp); err != nil {
-		return nil, fmt.Errorf("failed to decode vision response: %w", err)
+	// Set status for all parsed and capture original description
+	for i := range transactions {
+		transactions[i].OriginalDescription = transactions[i].Description
+		// Models do not always honour the requested date format
+		if date, err := ParseDate(transactions[i].Date, ""); err == nil {
+			transactions[i].Date = date
+		}
+		transactions[i].Status = models.StatusPending
+		// Remember the prompt, so that imports record which version read them
+		if transactions[i].Extraction == nil {
+			transactions[i].Extraction = &models.Extraction{}
+		}
+		transactions[i].Extraction.PromptName = promptTemplate.Name
+		transactions[i].Extraction.PromptVersion = promptTemplate.Version
 	}
 
-	if len(vResp.Choices) == 0 {
-		return nil, errors.New("no content parsed by vision API")
+	saveVisionResult(cfg, cacheKey, transactions)
+	return transactions, nil
+}
+
+// promptData returns the prompt variables for an upload. Dates without a year are assumed to
+// be in the year of the file date when known, otherwise today.
+func promptData(fileDate string, cfg VisionConfig) PromptData {
+	data := PromptData{
+		Today:      fileDate,
+		Account:    cfg.Account,
+		Currency:   cfg.Currency,
+		Categories: cfg.Categories,
 	}
+	if data.Today == "" {
+		data.Today = time.Now().Format("2006-01-02")
+	}
+	if len(data.Today) >= 4 {
+		data.Year = data.Today[:4]
+	} else {
+		data.Year = time.Now().Format("2006")
+	}
+	return data
+}
 
-	contentStr := vResp.Choices[0].Message.Content
+// extractTransactions asks the model for the transactions in one image.
+func extractTransactions(provider VisionProvider, model, prompt string, img VisionImage) ([]models.Transaction, error) {
+	req := VisionRequest{
+		Model:    model,
+		Messages: []VisionMessage{{Role: "user", Text: prompt, Images: []VisionImage{img}}},
+		Schema:   &transactionsSchema,
+	}
 
 	var transactions []models.Transaction
-	if err := json.Unmarshal([]byte(contentStr), &transactions); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON from vision response: %w, raw content: %s", err, contentStr)
+	err := askVision(provider, req, func(content string) (err error) {
+		transactions, err = decodeVisionTransactions(content)
+		return err
+	})
+	return transactions, err
+}
+
+// askVision sends a request and hands the answer to decode. One corrective retry is made: the
+// model gets its own answer back with the reason decode rejected it.
+func askVision(provider VisionProvider, req VisionRequest, decode func(content string) error) error {
+	for attempt := 1; ; attempt++ {
+		content, err := provider.Complete(req)
+		if err != nil {
+			return err
+		}
+
+		err = decode(content)
+		if err == nil {
+			return nil
+		}
+		if attempt == 2 {
+			return fmt.Errorf("failed to parse JSON from vision response: %w, raw content: %s", err, content)
+		}
+		req.Messages = append(req.Messages,
+			VisionMessage{Role: "assistant", Text: content},
+			VisionMessage{Role: "user", Text: "Your answer could not be used: " + err.Error() +
+				". Reply with only the corrected JSON, without any other text."},
+		)
 	}
+}
 
-	// Set status for all parsed and capture original description
+// lowConfidenceThreshold is the confidence below which a field is flagged for review.
+const lowConfidenceThreshold = 0.7
+
+// visionTransaction is a transaction as answered by the model, with the reading details that
+// become its Extraction.
+type visionTransaction struct {
+	models.Transaction
+	Confidence map[string]float64 `json:"confidence"`
+	Box        *models.Box        `json:"box"`
+}
+
+// extraction builds the Extraction of a model answer, flagging uncertain fields. Confidences
+// given as percentages are scaled down, and boxes outside the image are dropped.
+func (tx visionTransaction) extraction() *models.Extraction {
+	e := &models.Extraction{}
+	for _, field := range []string{"date", "description", "amount", "type"} {
+		c, ok := tx.Confidence[field]
+		if !ok {
+			continue
+		}
+		if c > 1 {
+			c /= 100
+		}
+		c = min(max(c, 0), 1)
+		if e.Confidence == nil {
+			e.Confidence = make(map[string]float64)
+		}
+		e.Confidence[field] = c
+		if c < lowConfidenceThreshold {
+			e.LowConfidence = append(e.LowConfidence, field)
+		}
+	}
+
+	const slack = 0.01 // rounding in the model's answer
+	if b := tx.Box; b != nil && b.Width > 0 && b.Height > 0 && b.X >= 0 && b.Y >= 0 &&
+		b.X+b.Width <= 1+slack && b.Y+b.Height <= 1+slack {
+		e.Box = b
+	}
+
+	if e.Confidence == nil && e.Box == nil {
+		return nil
+	}
+	return e
+}
+
+// locateRows crops the reported row of every transaction from the tile it was read from, and
+// maps its box from the tile onto the uploaded image.
+func locateRows(transactions []models.Transaction, tile visionTile) {
+	var src image.Image
+	decoded := false
 	for i := range transactions {
-		transactions[i].OriginalDescription = transactions[i].Description
-		transactions[i].Status = models.StatusPending
+		e := transactions[i].Extraction
+		if e == nil || e.Box == nil {
+			continue
+		}
+		if !decoded {
+			// HEIC and other formats that cannot be decoded get no crops
+			src, _, _ = image.Decode(bytes.NewReader(tile.Data))
+			decoded = true
+		}
+		if src != nil {
+			if crop, err := cropDataURL(src, *e.Box); err == nil {
+				e.Crop = crop
+			}
+		}
+
+		box := *e.Box
+		box.Y = tile.Top + box.Y*tile.Height
+		box.Height *= tile.Height
+		e.Box = &box
+	}
+}
+
+// decodeVisionTransactions reads the transactions from a model answer, either the structured
+// {"transactions": [...]} object or a bare array, and checks that every one is complete.
+func decodeVisionTransactions(content string) ([]models.Transaction, error) {
+	raw, err := extractJSON(content)
+	if err != nil {
+		return nil, err
 	}
 
+	var answers []visionTransaction
+	if raw[0] == '{' {
+		var wrapped struct {
+			Transactions *[]visionTransaction `json:"transactions"`
+		}
+		if err := json.Unmarshal(raw, &wrapped); err != nil {
+			return nil, err
+		}
+		if wrapped.Transactions == nil {
+			return nil, errors.New(`the JSON object has no "transactions" array`)
+		}
+		answers = *wrapped.Transactions
+	} else if err := json.Unmarshal(raw, &answers); err != nil {
+		return nil, err
+	}
+
+	transactions := make([]models.Transaction, len(answers))
+	for i, answer := range answers {
+		tx := answer.Transaction
+		switch {
+		case strings.TrimSpace(tx.Date) == "":
+			return nil, fmt.Errorf("transaction %d has no date", i+1)
+		case strings.TrimSpace(tx.Description) == "":
+			return nil, fmt.Errorf("transaction %d has no description", i+1)
+		case tx.Amount == 0:
+			return nil, fmt.Errorf("transaction %d has no amount", i+1)
+		case tx.Type != "withdrawal" && tx.Type != "deposit":
+			return nil, fmt.Errorf("transaction %d has type %q, expected \"withdrawal\" or \"deposit\"", i+1, tx.Type)
+		}
+		if tx.Amount < 0 {
+			tx.Amount = -tx.Amount
+		}
+		tx.Extraction = answer.extraction()
+		transactions[i] = tx
+	}
 	return transactions, nil
 }
+
+// extractJSON finds the first JSON array or object in a model answer, skipping markdown
+// fences and any prose around it.
+func extractJSON(content string) (json.RawMessage, error) {
+	for i := 0; i < len(content); i++ {
+		if content[i] != '[' && content[i] != '{' {
+			continue
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(content[i:])).Decode(&raw); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, errors.New("the answer contains no JSON array")
+}
+
+func init() {
+	Register(imageParser{})
+}
+
+type imageParser struct{}
+
+func (imageParser) Name() string { return "Image" }
+
+func (imageParser) Detect(filename string, head []byte) int {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")),
+		bytes.HasPrefix(head, []byte("\xff\xd8\xff")),
+		bytes.HasPrefix(head, []byte("GIF8")),
+		len(head) >= 12 && bytes.HasPrefix(head, []byte("RIFF")) && string(head[8:12]) == "WEBP",
+		strings.HasPrefix(DetectImageType(head), "image/hei"):
+		return 100
+	case hasExtension(filename, ".png", ".jpg", ".jpeg", ".gif", ".webp", ".heic", ".heif"):
+		return 50
+	}
+	return 0
+}
+
+func (imageParser) usesVision() {}
+
+func (imageParser) readsScreenshots() {}
+
+func (imageParser) Parse(r io.Reader, opts Options) ([]Statement, error) {
+	var txs []models.Transaction
+	var err error
+	if opts.Receipts {
+		txs, err = ParseReceipt(r, opts.FileDate, opts.Vision)
+	} else {
+		txs, err = ParseImageWithConfig(r, opts.FileDate, opts.Vision)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, nil), nil
+}
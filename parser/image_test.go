@@ -1,11 +1,16 @@
 package parser
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"firefly-importer/models"
 )
 
 func TestParseImage(t *testing.T) {
@@ -18,25 +23,7 @@ func TestParseImage(t *testing.T) {
 			t.Errorf("Expected Bearer test-key, got %s", r.Header.Get("Authorization"))
 		}
 
-		mockResponse := visionResponse{}
-		responseContent := `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.50,"type":"withdrawal"}]`
-
-		mockResponse.Choices = []struct {
-			Message struct {
-				Content string `json:"content"`
-			} `json:"message"`
-		}{
-			{
-				Message: struct {
-					Content string `json:"content"`
-				}{
-					Content: responseContent,
-				},
-			},
-		}
-
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(mockResponse)
+		visionReply(w, `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.50,"type":"withdrawal"}]`)
 	}))
 	defer mockServer.Close()
 
@@ -65,3 +52,223 @@ func TestParseImage(t *testing.T) {
 		t.Errorf("Expected Type withdrawal, got %s", txs[0].Type)
 	}
 }
+
+// visionReply encodes a chat completion response with the given content.
+func visionReply(w http.ResponseWriter, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"choices": []any{map[string]any{"message": map[string]string{"content": content}}},
+	})
+}
+
+func TestParseImageStructuredOutput(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResponseFormat *struct {
+				Type string `json:"type"`
+			} `json:"response_format"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" {
+			t.Errorf("Expected a json_schema response format, got %+v", req.ResponseFormat)
+		}
+		visionReply(w, `{"transactions":[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]}`)
+	}))
+	defer mockServer.Close()
+
+	txs, err := ParseImage(strings.NewReader("image"), "2023-11-15", mockServer.URL, "", "model")
+	if err != nil {
+		t.Fatalf("ParseImage failed: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Description != "Coffee Shop" {
+		t.Errorf("Expected the wrapped transaction, got %+v", txs)
+	}
+}
+
+func TestParseImageWithoutStructuredOutput(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		var req struct {
+			ResponseFormat *struct {
+				Type string `json:"type"`
+			} `json:"response_format"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ResponseFormat != nil {
+			http.Error(w, `{"error":"response_format is not supported"}`, http.StatusBadRequest)
+			return
+		}
+		visionReply(w, "Here are the transactions:\n```json\n"+
+			`[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]`+"\n```\nLet me know if you need more.")
+	}))
+	defer mockServer.Close()
+
+	for i := 0; i < 2; i++ {
+		txs, err := ParseImage(strings.NewReader("image"), "2023-11-15", mockServer.URL, "", "model")
+		if err != nil {
+			t.Fatalf("ParseImage failed: %v", err)
+		}
+		if len(txs) != 1 || txs[0].Amount != 4.5 {
+			t.Errorf("Expected the fenced transaction, got %+v", txs)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("Expected the rejected response format to be remembered (3 requests), got %d requests", requests)
+	}
+}
+
+func TestParseImageCorrectiveRetry(t *testing.T) {
+	var retryPrompt string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Role    string `json:"role"`
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if len(req.Messages) == 1 {
+			visionReply(w, `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"debit"}]`)
+			return
+		}
+		retryPrompt = req.Messages[len(req.Messages)-1].Content[0].Text
+		visionReply(w, `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]`)
+	}))
+	defer mockServer.Close()
+
+	txs, err := ParseImage(strings.NewReader("image"), "2023-11-15", mockServer.URL, "", "model")
+	if err != nil {
+		t.Fatalf("ParseImage failed: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Type != "withdrawal" {
+		t.Errorf("Expected the corrected transaction, got %+v", txs)
+	}
+	if !strings.Contains(retryPrompt, `type "debit"`) {
+		t.Errorf("Expected the retry to explain the invalid type, got %q", retryPrompt)
+	}
+}
+
+func TestParseImageExtraction(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		visionReply(w, fmt.Sprintf(`{"transactions":[{"date":"2023-11-15","description":"Row %d","amount":4.5,"type":"withdrawal",`+
+			`"confidence":{"date":0.4,"description":0.9,"amount":95,"type":1},"box":{"x":0,"y":0.5,"width":1,"height":0.25}}]}`, requests))
+	}))
+	defer mockServer.Close()
+
+	// 100 wide and 600 tall: four tiles starting at 0, 160, 320 and 480 pixels
+	cfg := VisionConfig{APIURL: mockServer.URL, Model: "model"}
+	txs, err := ParseImageWithConfig(bytes.NewReader(testPNG(t, 100, 600)), "2023-11-15", cfg)
+	if err != nil {
+		t.Fatalf("ParseImageWithConfig failed: %v", err)
+	}
+	if len(txs) != 4 {
+		t.Fatalf("Expected 4 transactions, got %d", len(txs))
+	}
+
+	e := txs[1].Extraction
+	if e == nil {
+		t.Fatal("Expected an extraction")
+	}
+	if e.Confidence["amount"] != 0.95 || e.Confidence["type"] != 1 {
+		t.Errorf("Expected amount confidence 0.95 and type 1, got %v", e.Confidence)
+	}
+	if len(e.LowConfidence) != 1 || e.LowConfidence[0] != "date" {
+		t.Errorf("Expected only the date to be flagged, got %v", e.LowConfidence)
+	}
+	if e.Box == nil || math.Abs(e.Box.Y-260.0/600) > 1e-9 || math.Abs(e.Box.Height-50.0/600) > 1e-9 {
+		t.Errorf("Expected the box to be mapped onto the second tile, got %+v", e.Box)
+	}
+	if !strings.HasPrefix(e.Crop, "data:image/jpeg;base64,") {
+		t.Errorf("Expected a JPEG crop, got %.40s", e.Crop)
+	}
+}
+
+func TestDecodeVisionTransactionsWithoutExtraction(t *testing.T) {
+	txs, err := decodeVisionTransactions(`[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal",` +
+		`"box":{"x":0.5,"y":0.5,"width":0.9,"height":0.1}}]`)
+	if err != nil {
+		t.Fatalf("decodeVisionTransactions failed: %v", err)
+	}
+	if txs[0].Extraction != nil {
+		t.Errorf("Expected a box outside the image to be dropped, got %+v", txs[0].Extraction)
+	}
+}
+
+// memoryVisionCache is a VisionCache backed by a map.
+type memoryVisionCache map[string][]models.Transaction
+
+func (c memoryVisionCache) GetVisionResult(key string) ([]models.Transaction, bool, error) {
+	txs, ok := c[key]
+	return txs, ok, nil
+}
+
+func (c memoryVisionCache) SaveVisionResult(key string, transactions []models.Transaction) error {
+	c[key] = transactions
+	return nil
+}
+
+func TestParseImageCache(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		visionReply(w, `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]`)
+	}))
+	defer mockServer.Close()
+
+	cache := memoryVisionCache{}
+	cfg := VisionConfig{APIURL: mockServer.URL, Model: "model", Cache: cache}
+	for i := 0; i < 2; i++ {
+		txs, err := ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+		if err != nil {
+			t.Fatalf("ParseImageWithConfig failed: %v", err)
+		}
+		if len(txs) != 1 || txs[0].Description != "Coffee Shop" || txs[0].Status != models.StatusPending {
+			t.Errorf("Expected the coffee shop transaction, got %+v", txs)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the second upload to be served from the cache, got %d requests", requests)
+	}
+
+	// Another model, file date, account or image limit, or an explicit refresh, reads the image again
+	cfg.Model = "other-model"
+	ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+	ParseImageWithConfig(strings.NewReader("image"), "2024-01-02", cfg)
+	cfg.Account = "Savings"
+	ParseImageWithConfig(strings.NewReader("image"), "2024-01-02", cfg)
+	cfg.MaxDimension = 1024
+	ParseImageWithConfig(strings.NewReader("image"), "2024-01-02", cfg)
+	cfg.Refresh = true
+	ParseImageWithConfig(strings.NewReader("image"), "2024-01-02", cfg)
+	if requests != 6 {
+		t.Errorf("Expected 6 requests, got %d", requests)
+	}
+	if len(cache) != 5 {
+		t.Errorf("Expected 5 cache entries, got %d", len(cache))
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`[{"a":1}]`, `[{"a":1}]`},
+		{"```json\n[{\"a\":1}]\n```", `[{"a":1}]`},
+		{"Sure [see below]:\n{\"transactions\":[]} Hope this helps!", `{"transactions":[]}`},
+	}
+	for _, tt := range tests {
+		got, err := extractJSON(tt.content)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("extractJSON(%q) = %s, %v; want %s", tt.content, got, err, tt.want)
+		}
+	}
+	if _, err := extractJSON("no transactions found"); err == nil {
+		t.Error("Expected an error for an answer without JSON")
+	}
+}
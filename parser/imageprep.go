@@ -0,0 +1,236 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"firefly-importer/models"
+
+	// Decoders for image.Decode
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Defaults for VisionConfig limits that are left at zero.
+const (
+	DefaultVisionMaxDimension = 2048
+	DefaultVisionMaxBytes     = 4 << 20
+)
+
+// Tall screenshots, such as scrolling captures, are split into tiles before they are sent, since
+// downscaling them to the maximum dimension would make the text unreadable.
+const (
+	tileAspectLimit = 2.5 // Images taller than this many times their width are tiled
+	tileAspect      = 2.0 // Height of a tile relative to the image width
+	tileOverlap     = 0.2 // Fraction of a tile repeated in the next one, so no row is cut in half
+)
+
+// DetectImageType returns the MIME type of an image from its magic bytes, including HEIC and
+// HEIF which http.DetectContentType does not know. It returns "" for anything else.
+func DetectImageType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" {
+		switch string(data[8:12]) {
+		case "heic", "heix", "heim", "heis", "hevc", "hevx":
+			return "image/heic"
+		case "mif1", "msf1", "heif":
+			return "image/heif"
+		}
+	}
+	if mimeType := http.DetectContentType(data); strings.HasPrefix(mimeType, "image/") {
+		return mimeType
+	}
+	return ""
+}
+
+// visionTile is an image sent to the Vision API together with the band of the uploaded image
+// it shows, so that regions the model reports can be mapped back onto the upload.
+type visionTile struct {
+	VisionImage
+	Top    float64 // Top edge, as a fraction of the uploaded image height
+	Height float64 // Height, as a fraction of the uploaded image height
+}
+
+// prepareVisionImages turns an uploaded image into the images sent to the Vision API. Images
+// within the configured limits are sent unchanged. Larger ones are downscaled and re-encoded,
+// very tall ones are split into overlapping tiles, and formats that not every endpoint accepts,
+// such as WebP, are converted to PNG. HEIC and HEIF cannot be decoded here, so they are sent
+// as is with their own MIME type for endpoints that read them. Ollama gets no MIME type with an
+// image and cannot read HEIC, so they are rejected for that provider.
+func prepareVisionImages(data []byte, cfg VisionConfig) ([]visionTile, error) {
+	maxDimension, maxBytes := visionLimits(cfg)
+
+	mimeType := DetectImageType(data)
+	if mimeType == "" {
+		mimeType = "image/jpeg"
+	}
+	heic := mimeType == "image/heic" || mimeType == "image/heif"
+	if heic && strings.EqualFold(strings.TrimSpace(cfg.Provider), VisionProviderOllama) {
+		return nil, errors.New("HEIC/HEIF images cannot be read by Ollama; export the photo as JPEG or PNG, or set the camera to \"Most Compatible\"")
+	}
+
+	// HEIC and anything else that cannot be decoded is passed through for the endpoint to read
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		if len(data) > maxBytes {
+			return nil, fmt.Errorf("%s image is larger than %d bytes and cannot be resized; convert it to JPEG or PNG", mimeType, maxBytes)
+		}
+		return []visionTile{{VisionImage: VisionImage{MIMEType: mimeType, Data: data}, Height: 1}}, nil
+	}
+
+	tiles := splitTall(src)
+	if len(tiles) == 1 && (mimeType == "image/png" || mimeType == "image/jpeg") && len(data) <= maxBytes &&
+		src.Bounds().Dx() <= maxDimension && src.Bounds().Dy() <= maxDimension {
+		return []visionTile{{VisionImage: VisionImage{MIMEType: mimeType, Data: data}, Height: 1}}, nil
+	}
+
+	images := make([]visionTile, 0, len(tiles))
+	height := float64(src.Bounds().Dy())
+	for _, tile := range tiles {
+		img, err := encodeWithinLimits(tile, mimeType == "image/jpeg", maxDimension, maxBytes)
+		if err != nil {
+			return nil, err
+		}
+		b := tile.Bounds()
+		images = append(images, visionTile{
+			VisionImage: img,
+			Top:         float64(b.Min.Y-src.Bounds().Min.Y) / height,
+			Height:      float64(b.Dy()) / height,
+		})
+	}
+	return images, nil
+}
+
+// visionLimits returns the configured image limits, with the defaults for those left at 0.
+func visionLimits(cfg VisionConfig) (maxDimension, maxBytes int) {
+	maxDimension, maxBytes = cfg.MaxDimension, cfg.MaxBytes
+	if maxDimension <= 0 {
+		maxDimension = DefaultVisionMaxDimension
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultVisionMaxBytes
+	}
+	return maxDimension, maxBytes
+}
+
+// splitTall splits an image taller than tileAspectLimit times its width into overlapping tiles
+// from top to bottom. Other images are returned whole.
+func splitTall(src image.Image) []image.Image {
+	b := src.Bounds()
+	if float64(b.Dy()) <= float64(b.Dx())*tileAspectLimit {
+		return []image.Image{src}
+	}
+
+	height := int(float64(b.Dx()) * tileAspect)
+	step := int(float64(height) * (1 - tileOverlap))
+	var tiles []image.Image
+	for top := b.Min.Y; ; top += step {
+		bottom := min(top+height, b.Max.Y)
+		tiles = append(tiles, subImage(src, image.Rect(b.Min.X, top, b.Max.X, bottom)))
+		if bottom == b.Max.Y {
+			return tiles
+		}
+	}
+}
+
+// subImage returns the part of src within r, copying it when src cannot be sliced.
+func subImage(src image.Image, r image.Rectangle) image.Image {
+	if s, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Copy(dst, image.Point{}, src, r, draw.Src, nil)
+	return dst
+}
+
+// encodeWithinLimits scales an image to fit maxDimension and encodes it in at most maxBytes.
+// Screenshots are kept as PNG for sharp text where they fit; otherwise JPEG quality is lowered
+// step by step, and the image is shrunk further when even the lowest quality is too large.
+func encodeWithinLimits(src image.Image, photo bool, maxDimension, maxBytes int) (VisionImage, error) {
+	img := scaleToFit(src, maxDimension)
+	for {
+		if !photo {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, img); err != nil {
+				return VisionImage{}, fmt.Errorf("failed to encode image: %w", err)
+			}
+			if buf.Len() <= maxBytes {
+				return VisionImage{MIMEType: "image/png", Data: buf.Bytes()}, nil
+			}
+		}
+
+		for quality := 85; quality >= 40; quality -= 15 {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return VisionImage{}, fmt.Errorf("failed to encode image: %w", err)
+			}
+			if buf.Len() <= maxBytes {
+				return VisionImage{MIMEType: "image/jpeg", Data: buf.Bytes()}, nil
+			}
+		}
+
+		b := img.Bounds()
+		if b.Dx() <= 64 || b.Dy() <= 64 {
+			return VisionImage{}, fmt.Errorf("image cannot be reduced to %d bytes", maxBytes)
+		}
+		img = scaleToFit(img, max(b.Dx(), b.Dy())*3/4)
+	}
+}
+
+// Crops of transaction rows shown on the review page are padded by a fraction of the image
+// height, since reported boxes are rarely exact, and kept small enough to embed in the page.
+const (
+	cropPadding  = 0.01
+	cropMaxWidth = 480
+)
+
+// cropDataURL returns a JPEG data URL of the region of an image within box, a rectangle in
+// fractions of the image size.
+func cropDataURL(src image.Image, box models.Box) (string, error) {
+	b := src.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	r := image.Rect(
+		b.Min.X+int(box.X*w), b.Min.Y+int((box.Y-cropPadding)*h),
+		b.Min.X+int((box.X+box.Width)*w), b.Min.Y+int((box.Y+box.Height+cropPadding)*h),
+	).Intersect(b)
+	if r.Empty() {
+		return "", fmt.Errorf("box %+v is outside the image", box)
+	}
+
+	crop := subImage(src, r)
+	if r.Dx() > cropMaxWidth {
+		scale := float64(cropMaxWidth) / float64(r.Dx())
+		dst := image.NewRGBA(image.Rect(0, 0, cropMaxWidth, max(1, int(float64(r.Dy())*scale))))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), crop, r, draw.Src, nil)
+		crop = dst
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, crop, &jpeg.Options{Quality: 75}); err != nil {
+		return "", fmt.Errorf("failed to encode crop: %w", err)
+	}
+	return VisionImage{MIMEType: "image/jpeg", Data: buf.Bytes()}.DataURL(), nil
+}
+
+// scaleToFit downscales an image so that neither side exceeds maxDimension.
+func scaleToFit(src image.Image, maxDimension int) image.Image {
+	b := src.Bounds()
+	if b.Dx() <= maxDimension && b.Dy() <= maxDimension {
+		return src
+	}
+	scale := float64(maxDimension) / float64(max(b.Dx(), b.Dy()))
+	width, height := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return dst
+}
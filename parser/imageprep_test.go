@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// testPNG encodes a width x height PNG with noise, so it does not compress to nothing.
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			seed = seed*1664525 + 1013904223
+			img.Set(x, y, color.RGBA{R: uint8(seed >> 24), G: uint8(seed >> 16), B: uint8(seed >> 8), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectImageType(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+		{"RIFF\x10\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "image/heic"},
+		{"\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00", "image/heif"},
+		{"Date,Description,Amount", ""},
+	}
+	for _, tt := range tests {
+		if got := DetectImageType([]byte(tt.data)); got != tt.want {
+			t.Errorf("DetectImageType(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestPrepareVisionImages(t *testing.T) {
+	small := testPNG(t, 100, 150)
+	images, err := prepareVisionImages(small, VisionConfig{})
+	if err != nil {
+		t.Fatalf("prepareVisionImages failed: %v", err)
+	}
+	if len(images) != 1 || images[0].MIMEType != "image/png" || !bytes.Equal(images[0].Data, small) {
+		t.Errorf("Expected a small PNG to be sent unchanged, got %d image(s)", len(images))
+	}
+
+	images, err = prepareVisionImages(testPNG(t, 300, 200), VisionConfig{MaxDimension: 150})
+	if err != nil {
+		t.Fatalf("prepareVisionImages failed: %v", err)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(images[0].Data))
+	if err != nil || cfg.Width != 150 || cfg.Height != 100 {
+		t.Errorf("Expected the image to be downscaled to 150x100, got %dx%d (%v)", cfg.Width, cfg.Height, err)
+	}
+
+	images, err = prepareVisionImages(testPNG(t, 200, 200), VisionConfig{MaxBytes: 20000})
+	if err != nil {
+		t.Fatalf("prepareVisionImages failed: %v", err)
+	}
+	if images[0].MIMEType != "image/jpeg" || len(images[0].Data) > 20000 {
+		t.Errorf("Expected a JPEG within 20000 bytes, got %s of %d bytes", images[0].MIMEType, len(images[0].Data))
+	}
+
+	// 100 wide and 600 tall: tiles of 200 pixels advancing by 160
+	images, err = prepareVisionImages(testPNG(t, 100, 600), VisionConfig{})
+	if err != nil {
+		t.Fatalf("prepareVisionImages failed: %v", err)
+	}
+	if len(images) != 4 {
+		t.Fatalf("Expected 4 tiles, got %d", len(images))
+	}
+	cfg, _, _ = image.DecodeConfig(bytes.NewReader(images[3].Data))
+	if cfg.Height != 120 {
+		t.Errorf("Expected the last tile to hold the remaining 120 pixels, got %d", cfg.Height)
+	}
+
+	heic := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00")
+	images, err = prepareVisionImages(heic, VisionConfig{})
+	if err != nil || images[0].MIMEType != "image/heic" || !bytes.Equal(images[0].Data, heic) {
+		t.Errorf("Expected HEIC to be passed through, got %v", err)
+	}
+	if _, err := prepareVisionImages(heic, VisionConfig{MaxBytes: 10}); err == nil {
+		t.Error("Expected an error for a HEIC image over the byte limit")
+	}
+	if _, err := prepareVisionImages(heic, VisionConfig{Provider: VisionProviderOllama}); err == nil || !strings.Contains(err.Error(), "HEIC") {
+		t.Errorf("Expected HEIC to be rejected for Ollama with a clear error, got %v", err)
+	}
+}
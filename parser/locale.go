@@ -0,0 +1,224 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dateLayouts are tried in order when a date format has to be detected. Unpadded layouts
+// also accept zero-padded values. Slashed dates are tried month-first (US) before
+// day-first, so a file only parses as day-first when it contains a day above 12.
+var dateLayouts = []string{
+	"2006-1-2",
+	"2.1.2006",
+	"2-1-2006",
+	"1/2/2006",
+	"2/1/2006",
+	"2.1.06",
+	"2-1-06",
+	"1/2/06",
+	"2/1/06",
+	"2006/1/2",
+	"2006.1.2",
+	"20060102",
+	"2 Jan 2006",
+	"2-Jan-2006",
+	"2-Jan-06",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"January 2, 2006",
+}
+
+// dateTokens maps human-readable format tokens onto Go layout elements. Longer tokens come first.
+var dateTokens = []struct {
+	token  string
+	layout string
+}{
+	{"YYYY", "2006"},
+	{"YY", "06"},
+	{"MMMM", "January"},
+	{"MMM", "Jan"},
+	{"MM", "01"},
+	{"M", "1"},
+	{"DD", "02"},
+	{"D", "2"},
+}
+
+// DateLayout converts a human-readable date format such as "DD.MM.YYYY" or "MM/DD/YY"
+// into a Go time layout. Formats that already are Go layouts are returned unchanged.
+func DateLayout(format string) string {
+	if strings.ContainsAny(format, "0123456789") {
+		return format // Human-readable formats never contain digits
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); {
+		matched := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(format[i:], t.token) {
+				b.WriteString(t.layout)
+				i += len(t.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(format[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// trimTime drops a time component from ISO-like timestamps such as "2025-12-31T10:00:00".
+func trimTime(s string) string {
+	if len(s) > 10 && s[4] == '-' && s[7] == '-' && (s[10] == 'T' || s[10] == ' ') {
+		return s[:10]
+	}
+	return s
+}
+
+// DetectDateFormat returns the known layout that parses the most non-empty samples, preferring
+// earlier layouts on a tie, or an empty string if none parses any. Samples that no layout
+// parses, such as a trailing totals row, do not affect the result.
+func DetectDateFormat(samples []string) string {
+	best, bestCount := "", 0
+	for _, layout := range dateLayouts {
+		count := 0
+		for _, s := range samples {
+			s = trimTime(strings.TrimSpace(s))
+			if s == "" {
+				continue
+			}
+			if _, err := time.Parse(layout, s); err == nil {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = layout, count
+		}
+	}
+	return best
+}
+
+// ParseDate parses a date with the given format (see DateLayout) and returns it as YYYY-MM-DD.
+// An empty format tries all known layouts.
+func ParseDate(s, format string) (string, error) {
+	s = trimTime(strings.TrimSpace(s))
+	if s == "" {
+		return "", errors.New("date is empty")
+	}
+
+	layouts := dateLayouts
+	if format != "" {
+		layouts = []string{DateLayout(format)}
+	}
+
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format("2006-01-02"), nil
+		}
+	}
+	if format != "" {
+		return "", fmt.Errorf("date %q does not match format %q", s, format)
+	}
+	return "", fmt.Errorf("unrecognized date %q", s)
+}
+
+// ParseAmount parses a bank-formatted amount such as "1.234,56", "-12,50 EUR", "$1,234.56",
+// "12,50-" or "(12.50)". decimalSep forces "." or "," as decimal separator; when empty it is
+// detected from the value. In detection, a single separator followed by exactly three digits
+// is read as a thousands separator.
+func ParseAmount(s, decimalSep string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("amount is empty")
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+
+	// Keep digits and separators only; currency symbols, codes and grouping spaces are dropped
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == '.' || r == ',':
+			b.WriteRune(r)
+		case r == '-' || r == '−': // leading or trailing minus
+			negative = true
+		}
+	}
+
+	cleaned := b.String()
+	if !strings.ContainsAny(cleaned, "0123456789") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	if decimalSep == "" {
+		decimalSep = detectDecimalSeparator(cleaned)
+	}
+
+	thousandsSep := ","
+	if decimalSep == "," {
+		thousandsSep = "."
+	}
+	cleaned = strings.ReplaceAll(cleaned, thousandsSep, "")
+	cleaned = strings.Replace(cleaned, decimalSep, ".", 1)
+
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// detectDecimalSeparator guesses the decimal separator of a value containing only digits,
+// dots and commas.
+func detectDecimalSeparator(v string) string {
+	lastDot := strings.LastIndex(v, ".")
+	lastComma := strings.LastIndex(v, ",")
+
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		if lastComma > lastDot {
+			return ","
+		}
+		return "."
+	case lastDot < 0 && lastComma < 0:
+		return "."
+	}
+
+	sep, idx := ".", lastDot
+	if lastComma >= 0 {
+		sep, idx = ",", lastComma
+	}
+
+	if strings.Count(v, sep) > 1 {
+		// Repeated separators can only group thousands
+		if sep == "," {
+			return "."
+		}
+		return ","
+	}
+
+	digitsAfter := len(v) - idx - 1
+	if digitsAfter == 3 && idx > 0 && !strings.HasPrefix(v, "0") {
+		if sep == "," {
+			return "."
+		}
+		return ","
+	}
+	return sep
+}
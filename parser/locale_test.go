@@ -0,0 +1,93 @@
+package parser
+
+import "testing"
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		input      string
+		decimalSep string
+		want       float64
+	}{
+		{"45.50", "", 45.50},
+		{"1.234,56", "", 1234.56},
+		{"1,234.56", "", 1234.56},
+		{"-12,50 EUR", "", -12.50},
+		{"€ 12,50", "", 12.50},
+		{"$1,234", "", 1234},
+		{"12,50-", "", -12.50},
+		{"(12.50)", "", -12.50},
+		{"1 234,56", "", 1234.56},
+		{"1'234.56", "", 1234.56},
+		{"1.234.567", "", 1234567},
+		{"0.125", "", 0.125},
+		{"1.234", ",", 1234},
+		{"1,234", ",", 1.234},
+		{"−7.00", "", -7},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.input, tt.decimalSep)
+		if err != nil {
+			t.Errorf("ParseAmount(%q, %q) failed: %v", tt.input, tt.decimalSep, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q, %q) = %f, want %f", tt.input, tt.decimalSep, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "EUR", "1.2.3,4,5"} {
+		if _, err := ParseAmount(bad, ""); err == nil {
+			t.Errorf("ParseAmount(%q) expected error, got nil", bad)
+		}
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+		want   string
+	}{
+		{"2025-12-31", "", "2025-12-31"},
+		{"31.12.2025", "", "2025-12-31"},
+		{"12/31/25", "", "2025-12-31"},
+		{"2025-12-31T10:00:00Z", "", "2025-12-31"},
+		{"31 Dec 2025", "", "2025-12-31"},
+		{"05/10/2025", "DD/MM/YYYY", "2025-10-05"},
+		{"1.2.25", "D.M.YY", "2025-02-01"},
+		{"20251231", "YYYYMMDD", "2025-12-31"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDate(tt.input, tt.format)
+		if err != nil {
+			t.Errorf("ParseDate(%q, %q) failed: %v", tt.input, tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseDate(%q, %q) = %s, want %s", tt.input, tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := ParseDate("31/12/2025", "MM/DD/YYYY"); err == nil {
+		t.Error("Expected error for date not matching format, got nil")
+	}
+}
+
+func TestDetectDateFormat(t *testing.T) {
+	// Ambiguous on its own, but 25/10 only parses day-first
+	layout := DetectDateFormat([]string{"05/10/2023", "25/10/2023"})
+	if layout != "2/1/2006" {
+		t.Errorf("Expected day-first layout, got %q", layout)
+	}
+
+	layout = DetectDateFormat([]string{"05/10/2023", "10/25/2023"})
+	if layout != "1/2/2006" {
+		t.Errorf("Expected month-first layout, got %q", layout)
+	}
+
+	if layout := DetectDateFormat([]string{"not a date"}); layout != "" {
+		t.Errorf("Expected no layout, got %q", layout)
+	}
+}
@@ -0,0 +1,356 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"firefly-importer/models"
+)
+
+var (
+	mt940TagPattern     = regexp.MustCompile(`^:(\d{2}[A-Z]?):(.*)$`)
+	mt940BalancePattern = regexp.MustCompile(`^([CD])(\d{6})([A-Z]{3})(\d+,\d*)$`)
+	// :61: value date, optional entry date, (reversal) debit/credit mark, optional funds code,
+	// amount, transaction type code, account owner reference and optional bank reference.
+	mt940EntryPattern   = regexp.MustCompile(`^(\d{6})(\d{4})?(RC|RD|C|D)([A-Z])?(\d+,\d*)([A-Z0-9]{4})([^/]*)(?://(.*))?$`)
+	mt940IBANPattern    = regexp.MustCompile(`^[A-Z]{2}\d{2}[A-Z0-9]{11,30}$`)
+	mt940SubfieldSplit  = regexp.MustCompile(`\?(\d{2})`)
+	mt940SlashCodes     = regexp.MustCompile(`/(TRTP|IBAN|BIC|NAME|REMI|EREF|MARF|CSID|ORDP|BENM|ADDR|ISDT|PREF|RTRN|ULTC|ULTD|PURP|ID)/`)
+	mt940SEPAKeyPattern = regexp.MustCompile(`[A-Z]{4}\+`)
+	// Detection looks for the :20: and :25: tags every statement starts with.
+	mt940StatementPattern = regexp.MustCompile(`(?m)^:20:`)
+	mt940AccountPattern   = regexp.MustCompile(`(?m)^:25:`)
+)
+
+type mt940Field struct {
+	tag   string
+	value string
+	line  int
+}
+
+// ParseMT940 reads a SWIFT MT940 export and returns one Statement per :20: block.
+// Each :61: line becomes a transaction described by the :86: narrative that follows it;
+// :60F: and :62F: are exposed as opening and closing balances for reconciliation.
+// :61: lines that cannot be parsed are reported as diagnostics of their statement.
+func ParseMT940(r io.Reader) ([]Statement, error) {
+	fields, err := readMT940Fields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []Statement
+	var current *Statement
+	var pending *models.Transaction
+	var pendingDiag *Diagnostic
+
+	flush := func() {
+		if pending != nil && current != nil {
+			current.Transactions = append(current.Transactions, *pending)
+		}
+		if pendingDiag != nil && current != nil {
+			current.Diagnostics = append(current.Diagnostics, *pendingDiag)
+		}
+		pending, pendingDiag = nil, nil
+	}
+
+	for _, f := range fields {
+		if f.tag != "20" && current == nil {
+			continue // Ignore anything before the first statement
+		}
+
+		switch f.tag {
+		case "20":
+			flush()
+			statements = append(statements, Statement{})
+			current = &statements[len(statements)-1]
+		case "25":
+			current.IBAN = mt940Account(f.value)
+		case "60F", "60M":
+			if current.OpeningBalance == nil {
+				if b, err := parseMT940Balance(f.value); err == nil {
+					current.OpeningBalance = b
+					current.Currency = b.Currency
+				}
+			}
+		case "61":
+			flush()
+			tx, err := parseMT940Entry(f.value)
+			if err != nil {
+				pendingDiag = &Diagnostic{
+					Line:        f.line,
+					Raw:         ":61:" + f.value,
+					Reason:      err.Error(),
+					Transaction: tx,
+				}
+				continue
+			}
+			pending = &tx
+		case "86":
+			if pending != nil {
+				applyMT940Narrative(pending, f.value)
+			}
+			if pendingDiag != nil {
+				applyMT940Narrative(&pendingDiag.Transaction, f.value)
+				pendingDiag.Raw += "\n:86:" + f.value
+			}
+		case "62F", "62M":
+			flush()
+			if b, err := parseMT940Balance(f.value); err == nil {
+				current.ClosingBalance = b
+			}
+		}
+	}
+	flush()
+
+	if len(statements) == 0 {
+		return nil, errors.New("no MT940 statements found")
+	}
+
+	return statements, nil
+}
+
+// readMT940Fields splits the file into tagged fields, joining continuation lines with "\n"
+// and skipping SWIFT block headers and message terminators.
+func readMT940Fields(r io.Reader) ([]mt940Field, error) {
+	var fields []mt940Field
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if line == "" || strings.HasPrefix(line, "{") || line == "-" || strings.HasPrefix(line, "-}") {
+			continue
+		}
+		if m := mt940TagPattern.FindStringSubmatch(line); m != nil {
+			fields = append(fields, mt940Field{tag: m[1], value: m[2], line: lineNo})
+			continue
+		}
+		if len(fields) > 0 {
+			fields[len(fields)-1].value += "\n" + line
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read MT940 file: %w", err)
+	}
+	return fields, nil
+}
+
+// mt940Account extracts the account from :25:, dropping a currency suffix some banks append to the IBAN.
+func mt940Account(v string) string {
+	v = NormalizeIBAN(v)
+	if len(v) > 3 && v[len(v)-4] >= '0' && v[len(v)-4] <= '9' && isUpperLetters(v[len(v)-3:]) {
+		if stripped := v[:len(v)-3]; mt940IBANPattern.MatchString(stripped) {
+			return stripped
+		}
+	}
+	return v
+}
+
+func isUpperLetters(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func parseMT940Date(v string) (string, error) {
+	date, err := ParseDate(v, "YYMMDD")
+	if err != nil {
+		return "", fmt.Errorf("invalid MT940 date %q: %w", v, err)
+	}
+	return date, nil
+}
+
+func parseMT940Amount(v string) (float64, error) {
+	return ParseAmount(v, ",")
+}
+
+func parseMT940Balance(v string) (*Balance, error) {
+	m := mt940BalancePattern.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return nil, fmt.Errorf("invalid MT940 balance %q", v)
+	}
+	date, err := parseMT940Date(m[2])
+	if err != nil {
+		return nil, err
+	}
+	amount, err := parseMT940Amount(m[4])
+	if err != nil {
+		return nil, fmt.Errorf("invalid MT940 balance amount %q: %w", m[4], err)
+	}
+	if m[1] == "D" {
+		amount = -amount
+	}
+	return &Balance{Date: date, Amount: amount, Currency: m[3]}, nil
+}
+
+// parseMT940Entry maps a :61: field onto a transaction. On error the returned transaction holds
+// the fields that could be read.
+func parseMT940Entry(v string) (models.Transaction, error) {
+	firstLine, supplementary, _ := strings.Cut(v, "\n")
+	description := strings.TrimSpace(supplementary)
+	tx := models.Transaction{
+		Description:         description,
+		OriginalDescription: description,
+		Status:              models.StatusPending,
+	}
+
+	m := mt940EntryPattern.FindStringSubmatch(strings.TrimSpace(firstLine))
+	if m == nil {
+		// The value date still leads a line that is malformed further on
+		if len(firstLine) >= 6 {
+			if date, err := parseMT940Date(firstLine[:6]); err == nil {
+				tx.Date = date
+			}
+		}
+		return tx, fmt.Errorf("invalid :61: line %q", firstLine)
+	}
+
+	// A reversed credit takes money out again, a reversed debit brings it back
+	tx.Type = "deposit"
+	if m[3] == "D" || m[3] == "RC" {
+		tx.Type = "withdrawal"
+	}
+
+	ownerRef := strings.TrimSpace(m[7])
+	bankRef := strings.TrimSpace(m[8])
+	tx.ExternalID = bankRef
+	if tx.ExternalID == "" && ownerRef != "NONREF" {
+		tx.ExternalID = ownerRef
+	}
+	if description == "" && ownerRef != "NONREF" {
+		tx.Description, tx.OriginalDescription = ownerRef, ownerRef
+	}
+
+	amount, amountErr := parseMT940Amount(m[5])
+	if amountErr == nil {
+		tx.Amount = amount
+	}
+
+	date, err := parseMT940Date(m[1])
+	if err != nil {
+		tx.Date = m[1] // Kept so the row can be fixed on the review page
+		return tx, err
+	}
+	tx.Date = date
+	if amountErr != nil {
+		return tx, fmt.Errorf("invalid :61: amount %q: %w", m[5], amountErr)
+	}
+	return tx, nil
+}
+
+// applyMT940Narrative fills description and counterparty from an :86: field. It understands
+// German ?NN subfields and the /CODE/value layout used by Dutch banks, and otherwise
+// uses the free-text narrative as is.
+func applyMT940Narrative(tx *models.Transaction, narrative string) {
+	var description, counterparty string
+
+	switch {
+	case mt940SubfieldSplit.MatchString(narrative):
+		description, counterparty = parseMT940Subfields(strings.ReplaceAll(narrative, "\n", ""))
+	case mt940SlashCodes.MatchString(strings.ReplaceAll(narrative, "\n", "")):
+		description, counterparty = parseMT940SlashCodes(strings.ReplaceAll(narrative, "\n", ""))
+	default:
+		description = strings.Join(strings.Fields(narrative), " ")
+	}
+
+	if description == "" {
+		description = counterparty
+	}
+	if description != "" {
+		tx.Description = description
+		tx.OriginalDescription = description
+	}
+	if counterparty != "" {
+		if tx.Type == "withdrawal" {
+			tx.DestinationName = counterparty
+		} else {
+			tx.SourceName = counterparty
+		}
+	}
+}
+
+func parseMT940Subfields(narrative string) (description, counterparty string) {
+	codes := mt940SubfieldSplit.FindAllStringSubmatchIndex(narrative, -1)
+	var purpose, name strings.Builder
+
+	for i, loc := range codes {
+		end := len(narrative)
+		if i+1 < len(codes) {
+			end = codes[i+1][0]
+		}
+		code, _ := strconv.Atoi(narrative[loc[2]:loc[3]])
+		value := narrative[loc[1]:end]
+
+		switch {
+		case (code >= 20 && code <= 29) || (code >= 60 && code <= 63):
+			purpose.WriteString(value)
+		case code == 32 || code == 33:
+			name.WriteString(value)
+		}
+	}
+
+	description = purpose.String()
+	// SEPA purposes are prefixed with keys like EREF+ and SVWZ+; keep only the remittance text
+	if idx := strings.Index(description, "SVWZ+"); idx >= 0 {
+		description = description[idx+len("SVWZ+"):]
+		if next := mt940SEPAKeyPattern.FindStringIndex(description); next != nil {
+			description = description[:next[0]]
+		}
+	}
+
+	return strings.TrimSpace(description), strings.TrimSpace(name.String())
+}
+
+func parseMT940SlashCodes(narrative string) (description, counterparty string) {
+	codes := mt940SlashCodes.FindAllStringSubmatchIndex(narrative, -1)
+	values := make(map[string]string)
+
+	for i, loc := range codes {
+		end := len(narrative)
+		if i+1 < len(codes) {
+			end = codes[i+1][0]
+		}
+		code := narrative[loc[2]:loc[3]]
+		if _, seen := values[code]; !seen {
+			values[code] = strings.Trim(narrative[loc[1]:end], "/ ")
+		}
+	}
+
+	description = strings.TrimPrefix(values["REMI"], "USTD//")
+	return strings.Trim(description, "/ "), values["NAME"]
+}
+
+func init() {
+	Register(mt940Parser{})
+}
+
+type mt940Parser struct{}
+
+func (mt940Parser) Name() string { return "MT940" }
+
+func (mt940Parser) Detect(filename string, head []byte) int {
+	text := textHead(head)
+	switch {
+	case strings.Contains(text, "{2:I940") || strings.Contains(text, "{2:O940"):
+		return 100
+	case mt940StatementPattern.MatchString(text) && mt940AccountPattern.MatchString(text):
+		return 90
+	case hasExtension(filename, ".sta", ".mt940", ".940"):
+		return 50
+	}
+	return 0
+}
+
+func (mt940Parser) Parse(r io.Reader, _ Options) ([]Statement, error) {
+	return ParseMT940(r)
+}
@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseMT940(t *testing.T) {
+	mt940Data := `{1:F01ABNANL2AXXXX0000000000}{2:I940ABNANL2AXXXXN}{4:
+:20:STATEMENT-001
+:25:NL91ABNA0417164300EUR
+:28C:00001/001
+:60F:C231001EUR1000,00
+:61:2310011001D45,50NTRFNONREF//BANKREF-1
+:86:/TRTP/SEPA OVERBOEKING/IBAN/NL20INGB0001234567/BIC/INGBNL2A/NAME/Albert
+ Heijn/REMI/USTD//Groceries week 40/EREF/NOTPROVIDED
+:61:231002C1500,00NTRFSALARY-10
+:86:166?00GUTSCHRIFT?20SVWZ+Salary October?21 2023?32ACME?33 GmbH
+:61:231003D4,50NMSCNONREF
+:86:Coffee at the
+station
+:62F:C231003EUR2450,00
+-}`
+
+	statements, err := ParseMT940(strings.NewReader(mt940Data))
+	if err != nil {
+		t.Fatalf("ParseMT940 failed: %v", err)
+	}
+
+	if len(statements) != 1 {
+		t.Fatalf("Expected 1 statement, got %d", len(statements))
+	}
+
+	stmt := statements[0]
+	if stmt.IBAN != "NL91ABNA0417164300" {
+		t.Errorf("Expected IBAN without currency suffix, got %s", stmt.IBAN)
+	}
+	if stmt.OpeningBalance == nil || stmt.OpeningBalance.Amount != 1000.00 {
+		t.Errorf("Expected opening balance 1000.00, got %+v", stmt.OpeningBalance)
+	}
+	if stmt.ClosingBalance == nil || stmt.ClosingBalance.Amount != 2450.00 || stmt.ClosingBalance.Date != "2023-10-03" {
+		t.Errorf("Expected closing balance 2450.00 on 2023-10-03, got %+v", stmt.ClosingBalance)
+	}
+
+	if len(stmt.Transactions) != 3 {
+		t.Fatalf("Expected 3 transactions, got %d", len(stmt.Transactions))
+	}
+
+	first := stmt.Transactions[0]
+	if first.Date != "2023-10-01" || first.Amount != 45.50 || first.Type != "withdrawal" {
+		t.Errorf("Unexpected first transaction: %+v", first)
+	}
+	if first.Description != "Groceries week 40" {
+		t.Errorf("Expected Description from /REMI/, got %q", first.Description)
+	}
+	if first.DestinationName != "Albert Heijn" {
+		t.Errorf("Expected DestinationName Albert Heijn, got %q", first.DestinationName)
+	}
+	if first.ExternalID != "BANKREF-1" {
+		t.Errorf("Expected ExternalID BANKREF-1, got %q", first.ExternalID)
+	}
+
+	second := stmt.Transactions[1]
+	if second.Type != "deposit" || second.Description != "Salary October 2023" || second.SourceName != "ACME GmbH" {
+		t.Errorf("Unexpected second transaction: %+v", second)
+	}
+
+	third := stmt.Transactions[2]
+	if third.Description != "Coffee at the station" {
+		t.Errorf("Expected multi-line :86: narrative to be joined, got %q", third.Description)
+	}
+
+	if err := stmt.Reconcile(); err != nil {
+		t.Errorf("Expected statement to reconcile, got %v", err)
+	}
+}
+
+func TestParseMT940Diagnostics(t *testing.T) {
+	mt940Data := `:20:STATEMENT-002
+:25:NL91ABNA0417164300
+:61:231004X12,00NMSCNONREF
+:86:Parking garage
+:61:231005D3,00NMSCNONREF
+:86:Coffee
+:61:231306D5,50NMSCNONREF
+:86:Bakery
+`
+
+	statements, err := ParseMT940(strings.NewReader(mt940Data))
+	if err != nil {
+		t.Fatalf("ParseMT940 failed: %v", err)
+	}
+
+	stmt := statements[0]
+	if len(stmt.Transactions) != 1 {
+		t.Errorf("Expected 1 transaction, got %d", len(stmt.Transactions))
+	}
+	if len(stmt.Diagnostics) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %d", len(stmt.Diagnostics))
+	}
+	diag := stmt.Diagnostics[0]
+	if diag.Line != 3 || diag.Transaction.Description != "Parking garage" || diag.Transaction.Date != "2023-10-04" {
+		t.Errorf("Unexpected diagnostic: %+v", diag)
+	}
+
+	// Everything but the date could be read, so only the date is left to fix
+	tx := stmt.Diagnostics[1].Transaction
+	if tx.Date != "231306" || tx.Amount != 5.50 || tx.Type != "withdrawal" || tx.Description != "Bakery" {
+		t.Errorf("Expected the fields that parsed to be kept, got %+v", tx)
+	}
+}
+
+func TestStatementReconcileMismatch(t *testing.T) {
+	mt940Data := `:20:STATEMENT-002
+:25:NL91ABNA0417164300
+:60F:C231001EUR100,00
+:61:231001D10,00NMSCNONREF
+:62F:C231001EUR95,00`
+
+	statements, err := ParseMT940(strings.NewReader(mt940Data))
+	if err != nil {
+		t.Fatalf("ParseMT940 failed: %v", err)
+	}
+
+	if err := statements[0].Reconcile(); err == nil {
+		t.Error("Expected balance mismatch error, got nil")
+	}
+}
@@ -0,0 +1,206 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+
+	"firefly-importer/models"
+)
+
+// ofxTagPattern matches an opening or closing OFX tag followed by its text content.
+// It works for both SGML (OFX 1.x, leaf elements are not closed) and XML (OFX 2.x).
+var ofxTagPattern = regexp.MustCompile(`<(/?)([A-Za-z0-9_.]+)>([^<]*)`)
+
+// ofxDebitTypes are TRNTYPE values that denote money leaving the account even when
+// a bank exports them with an unsigned amount.
+var ofxDebitTypes = map[string]bool{
+	"DEBIT":       true,
+	"PAYMENT":     true,
+	"FEE":         true,
+	"SRVCHG":      true,
+	"ATM":         true,
+	"CHECK":       true,
+	"DIRECTDEBIT": true,
+}
+
+type ofxEntry struct {
+	trnType  string
+	posted   string
+	amount   string
+	fitID    string
+	name     string
+	memo     string
+	checkNum string
+	line     int // Line of the opening <STMTTRN> tag
+	start    int // Offset of the opening <STMTTRN> tag
+}
+
+// ParseOFX reads an OFX or QFX statement (SGML 1.x or XML 2.x) and maps every <STMTTRN>
+// entry to a models.Transaction. The FITID is kept as ExternalID for deduplication.
+// Entries without a usable date or amount are returned as diagnostics.
+func ParseOFX(r io.Reader) ([]models.Transaction, []Diagnostic, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read ofx file: %w", err)
+	}
+
+	content := string(data)
+	start := strings.Index(strings.ToUpper(content), "<OFX>")
+	if start < 0 {
+		return nil, nil, errors.New("no <OFX> element found")
+	}
+
+	var transactions []models.Transaction
+	var diagnostics []Diagnostic
+	var current *ofxEntry
+
+	for _, loc := range ofxTagPattern.FindAllStringSubmatchIndex(content[start:], -1) {
+		closing := loc[3] > loc[2]
+		tag := strings.ToUpper(content[start+loc[4] : start+loc[5]])
+		value := strings.TrimSpace(html.UnescapeString(content[start+loc[6] : start+loc[7]]))
+
+		if tag == "STMTTRN" {
+			if closing {
+				if current != nil {
+					tx, err := current.toTransaction()
+					if err != nil {
+						diagnostics = append(diagnostics, Diagnostic{
+							Line:        current.line,
+							Raw:         strings.TrimSpace(content[current.start : start+loc[0]+len("</STMTTRN>")]),
+							Reason:      err.Error(),
+							Transaction: tx,
+						})
+					} else {
+						transactions = append(transactions, tx)
+					}
+				}
+				current = nil
+			} else {
+				current = &ofxEntry{
+					line:  strings.Count(content[:start+loc[0]], "\n") + 1,
+					start: start + loc[0],
+				}
+			}
+			continue
+		}
+
+		if current == nil || closing || value == "" {
+			continue
+		}
+
+		switch tag {
+		case "TRNTYPE":
+			current.trnType = strings.ToUpper(value)
+		case "DTPOSTED":
+			current.posted = value
+		case "TRNAMT":
+			current.amount = value
+		case "FITID":
+			current.fitID = value
+		case "NAME":
+			current.name = value
+		case "MEMO":
+			current.memo = value
+		case "CHECKNUM":
+			current.checkNum = value
+		}
+	}
+
+	return transactions, diagnostics, nil
+}
+
+// parseOFXDate converts an OFX datetime (YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]]) to YYYY-MM-DD.
+func parseOFXDate(v string) (string, error) {
+	if len(v) < 8 {
+		return "", fmt.Errorf("invalid ofx date %q", v)
+	}
+	date, err := ParseDate(v[:8], "YYYYMMDD")
+	if err != nil {
+		return "", fmt.Errorf("invalid ofx date %q: %w", v, err)
+	}
+	return date, nil
+}
+
+// ofxDecimalSeparator returns the decimal separator of an OFX amount. The spec requires a
+// decimal point, so "-1.500" is one and a half; only amounts with a comma and no point, which
+// some European banks write, use a decimal comma.
+func ofxDecimalSeparator(amount string) string {
+	if strings.Contains(amount, ",") && !strings.Contains(amount, ".") {
+		return ","
+	}
+	return "."
+}
+
+// toTransaction maps an entry onto a transaction. On error the returned transaction holds the
+// fields that could be read.
+func (e *ofxEntry) toTransaction() (models.Transaction, error) {
+	description := e.name
+	if description == "" {
+		description = e.memo
+	}
+	if description == "" && e.checkNum != "" {
+		description = "Check " + e.checkNum
+	}
+
+	tx := models.Transaction{
+		Date:                e.posted,
+		Description:         description,
+		OriginalDescription: description,
+		ExternalID:          e.fitID,
+		Status:              models.StatusPending,
+	}
+
+	date, err := parseOFXDate(e.posted)
+	if err != nil {
+		return tx, err
+	}
+	tx.Date = date
+
+	amount, err := ParseAmount(e.amount, ofxDecimalSeparator(e.amount))
+	if err != nil {
+		return tx, err
+	}
+
+	txType := "deposit"
+	if amount < 0 || (amount > 0 && ofxDebitTypes[e.trnType]) {
+		txType = "withdrawal"
+	}
+	if amount < 0 {
+		amount = -amount
+	}
+
+	tx.Amount = amount
+	tx.Type = txType
+	return tx, nil
+}
+
+func init() {
+	Register(ofxParser{})
+}
+
+type ofxParser struct{}
+
+func (ofxParser) Name() string { return "OFX" }
+
+func (ofxParser) Detect(filename string, head []byte) int {
+	upper := strings.ToUpper(string(head))
+	if strings.Contains(upper, "OFXHEADER") || strings.Contains(upper, "<OFX>") {
+		return 100
+	}
+	if hasExtension(filename, ".ofx", ".qfx") {
+		return 50
+	}
+	return 0
+}
+
+func (ofxParser) Parse(r io.Reader, _ Options) ([]Statement, error) {
+	txs, diags, err := ParseOFX(r)
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, diags), nil
+}
@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOFXSGML(t *testing.T) {
+	ofxData := `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+CHARSET:1252
+
+<OFX>
+<BANKMSGSRSV1>
+<STMTTRNRS>
+<STMTRS>
+<CURDEF>USD
+<BANKTRANLIST>
+<DTSTART>20231001
+<DTEND>20231031
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20231001120000.000[-5:EST]
+<TRNAMT>-45.50
+<FITID>2023100101
+<NAME>GROCERY STORE
+<MEMO>Card purchase
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20231002
+<TRNAMT>1500.00
+<FITID>2023100202
+<NAME>ACME PAYROLL &amp; CO
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>bad
+<TRNAMT>-1.00
+<FITID>2023100303
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS>
+</STMTTRNRS>
+</BANKMSGSRSV1>
+</OFX>`
+
+	txs, diags, err := ParseOFX(strings.NewReader(ofxData))
+	if err != nil {
+		t.Fatalf("ParseOFX failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+
+	if txs[0].Date != "2023-10-01" {
+		t.Errorf("Expected Date 2023-10-01, got %s", txs[0].Date)
+	}
+	if txs[0].Description != "GROCERY STORE" {
+		t.Errorf("Expected Description GROCERY STORE, got %s", txs[0].Description)
+	}
+	if txs[0].Amount != 45.50 {
+		t.Errorf("Expected Amount 45.50, got %f", txs[0].Amount)
+	}
+	if txs[0].Type != "withdrawal" {
+		t.Errorf("Expected Type withdrawal, got %s", txs[0].Type)
+	}
+	if txs[0].ExternalID != "2023100101" {
+		t.Errorf("Expected ExternalID 2023100101, got %s", txs[0].ExternalID)
+	}
+
+	if txs[1].Type != "deposit" {
+		t.Errorf("Expected Type deposit, got %s", txs[1].Type)
+	}
+	if txs[1].Description != "ACME PAYROLL & CO" {
+		t.Errorf("Expected Description ACME PAYROLL & CO, got %s", txs[1].Description)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d", len(diags))
+	}
+	if diags[0].Line != 29 || diags[0].Transaction.ExternalID != "2023100303" || !strings.HasPrefix(diags[0].Raw, "<STMTTRN>") {
+		t.Errorf("Unexpected diagnostic: %+v", diags[0])
+	}
+}
+
+func TestParseOFXXML(t *testing.T) {
+	ofxData := `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="220" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1>
+    <CCSTMTTRNRS>
+      <CCSTMTRS>
+        <BANKTRANLIST>
+          <STMTTRN>
+            <TRNTYPE>PAYMENT</TRNTYPE>
+            <DTPOSTED>20231105</DTPOSTED>
+            <TRNAMT>12.99</TRNAMT>
+            <FITID>CC-1</FITID>
+            <MEMO>Streaming subscription</MEMO>
+          </STMTTRN>
+        </BANKTRANLIST>
+      </CCSTMTRS>
+    </CCSTMTTRNRS>
+  </CREDITCARDMSGSRSV1>
+</OFX>`
+
+	txs, _, err := ParseOFX(strings.NewReader(ofxData))
+	if err != nil {
+		t.Fatalf("ParseOFX failed: %v", err)
+	}
+
+	if len(txs) != 1 {
+		t.Fatalf("Expected 1 transaction, got %d", len(txs))
+	}
+
+	// Unsigned PAYMENT amounts are still money leaving the account
+	if txs[0].Type != "withdrawal" {
+		t.Errorf("Expected Type withdrawal, got %s", txs[0].Type)
+	}
+	if txs[0].Description != "Streaming subscription" {
+		t.Errorf("Expected Description from MEMO, got %s", txs[0].Description)
+	}
+	if txs[0].ExternalID != "CC-1" {
+		t.Errorf("Expected ExternalID CC-1, got %s", txs[0].ExternalID)
+	}
+}
+
+func TestOFXAmountDecimalPoint(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   float64
+	}{
+		{"-1.500", -1.5}, // Three decimals, not a thousands separator
+		{"1500.00", 1500},
+		{"-12,50", -12.5}, // Decimal comma written by some European banks
+		{"1,500.25", 1500.25},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.amount, ofxDecimalSeparator(tt.amount))
+		if err != nil || got != tt.want {
+			t.Errorf("OFX amount %q: expected %v, got %v (%v)", tt.amount, tt.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+
+	"firefly-importer/models"
+)
+
+// DefaultLinePattern matches statement lines such as "03.02.2025 Coffee Shop -3,50 1.234,56":
+// a date, a description and an amount, optionally followed by the running balance.
+const DefaultLinePattern = `^(?P<date>\d{1,2}[./-]\d{1,2}[./-]\d{2,4}|\d{4}-\d{2}-\d{2})\s+(?P<description>.+?)\s+` +
+	`(?P<amount>[-+−]?\d{1,3}(?:[.,' ]?\d{3})*[.,]\d{2}\s?[-+]?)(?:\s+(?P<balance>[-+−]?\d{1,3}(?:[.,' ]?\d{3})*[.,]\d{2}\s?[-+]?))?$`
+
+// Named groups of a line pattern that map onto transaction fields.
+const (
+	lineGroupDate         = "date"
+	lineGroupDescription  = "description"
+	lineGroupAmount       = "amount"
+	lineGroupDebit        = "debit"
+	lineGroupCredit       = "credit"
+	lineGroupType         = "type"
+	lineGroupCounterparty = "counterparty"
+)
+
+// compileLinePattern compiles a profile's line pattern and checks that it captures a date, a
+// description and either an amount or a debit and a credit amount.
+func compileLinePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid line pattern: %w", err)
+	}
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		groups[name] = true
+	}
+	if !groups[lineGroupDate] || !groups[lineGroupDescription] {
+		return nil, errors.New("line pattern must have a date and a description group")
+	}
+	if !groups[lineGroupAmount] && !(groups[lineGroupDebit] && groups[lineGroupCredit]) {
+		return nil, errors.New("line pattern must have an amount group or both a debit and a credit group")
+	}
+	return re, nil
+}
+
+// linePatternProfile derives the column mapping of the records produced by a line pattern,
+// whose header is the pattern's group names. Date and amount settings come from the profile.
+func linePatternProfile(re *regexp.Regexp, profile models.ImportProfile) models.ImportProfile {
+	groups := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		groups[name] = true
+	}
+	optional := func(name string) string {
+		if groups[name] {
+			return name
+		}
+		return ""
+	}
+
+	p := models.ImportProfile{
+		HasHeader:          true,
+		DateColumn:         lineGroupDate,
+		DescriptionColumn:  lineGroupDescription,
+		AmountColumn:       optional(lineGroupAmount),
+		TypeColumn:         optional(lineGroupType),
+		CounterpartyColumn: optional(lineGroupCounterparty),
+		InvertSign:         profile.InvertSign,
+		DateFormat:         profile.DateFormat,
+		DecimalSeparator:   profile.DecimalSeparator,
+	}
+	if groups[lineGroupDebit] && groups[lineGroupCredit] && !groups[lineGroupAmount] {
+		p.AmountMode = models.AmountModeSplit
+		p.DebitAmountColumn = lineGroupDebit
+		p.CreditAmountColumn = lineGroupCredit
+	}
+	return p
+}
+
+// ParsePDF reads the text layer of a PDF bank statement and maps every line that matches the
+// profile's line pattern (DefaultLinePattern when empty) to a transaction. Pages without text,
+// such as scans, are sent one at a time to the Vision API. Matching lines whose date or amount
+// cannot be read are returned as diagnostics; all other lines are ignored.
+func ParsePDF(r io.Reader, opts Options) ([]models.Transaction, []Diagnostic, error) {
+	pattern := opts.Profile.LinePattern
+	if pattern == "" {
+		pattern = DefaultLinePattern
+	}
+	re, err := compileLinePattern(pattern)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read pdf file: %w", err)
+	}
+	pages, err := readPDFPages(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pages) == 0 {
+		return nil, nil, errors.New("pdf file has no pages")
+	}
+
+	header := re.SubexpNames()
+	rows := []sourceRow{{record: header}}
+	lineText := make(map[int]string)
+
+	var visionTxs []models.Transaction
+	var diagnostics []Diagnostic
+	lineNo, hasText := 0, false
+
+	for i, page := range pages {
+		if len(page.lines) == 0 {
+			txs, diag := page.readWithVision(i+1, opts)
+			visionTxs = append(visionTxs, txs...)
+			if diag != nil {
+				diagnostics = append(diagnostics, *diag)
+			}
+			continue
+		}
+
+		hasText = true
+		for _, line := range page.lines {
+			lineNo++
+			match := re.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			lineText[lineNo] = line
+			rows = append(rows, sourceRow{line: lineNo, record: match})
+		}
+	}
+
+	if !hasText && len(visionTxs) == 0 {
+		if opts.Vision.APIURL == "" {
+			return nil, nil, errors.New("PDF has no text layer and no vision API is configured to read it")
+		}
+		if len(diagnostics) > 0 {
+			return nil, nil, errors.New(diagnostics[0].Reason)
+		}
+	}
+	if hasText && len(rows) == 1 && len(visionTxs) == 0 {
+		return nil, nil, errors.New("no lines of the PDF match the line pattern")
+	}
+
+	txs, diags, err := mapRows(rows, linePatternProfile(re, opts.Profile), ' ')
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range diags {
+		diags[i].Raw = lineText[diags[i].Line]
+	}
+
+	return append(txs, visionTxs...), append(diagnostics, diags...), nil
+}
+
+// pdfPage is the text of one page, or the embedded images of a page without text.
+type pdfPage struct {
+	lines  []string
+	images [][]byte // PNG or JPEG, largest first
+}
+
+// readWithVision sends the largest image of a page without text to the Vision API. Failures
+// are returned as a diagnostic so the other pages are still imported.
+func (p pdfPage) readWithVision(number int, opts Options) ([]models.Transaction, *Diagnostic) {
+	raw := fmt.Sprintf("page %d", number)
+	switch {
+	case opts.Vision.APIURL == "":
+		return nil, &Diagnostic{Raw: raw, Reason: fmt.Sprintf("page %d has no text layer and no vision API is configured", number)}
+	case len(p.images) == 0:
+		return nil, &Diagnostic{Raw: raw, Reason: fmt.Sprintf("page %d has no text layer and no readable image", number)}
+	}
+
+	txs, err := ParseImageWithConfig(bytes.NewReader(p.images[0]), opts.FileDate, opts.Vision)
+	if err != nil {
+		return nil, &Diagnostic{Raw: raw, Reason: fmt.Sprintf("page %d: %v", number, err)}
+	}
+	return txs, nil
+}
+
+// readPDFPages extracts the text lines of every page, and the images of pages without text.
+// The PDF library panics on some malformed files, which is reported as an error.
+func readPDFPages(data []byte) (pages []pdfPage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to read pdf file: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pdf file: %w", err)
+	}
+
+	jpegs := pdfJPEGStreams(data)
+	for i := 1; i <= reader.NumPage(); i++ {
+		page := reader.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		lines := pdfPageLines(page)
+		var images [][]byte
+		if len(lines) == 0 {
+			images = pdfPageImages(page, jpegs)
+		}
+		pages = append(pages, pdfPage{lines: lines, images: images})
+	}
+	return pages, nil
+}
+
+// pdfPageLines groups the glyphs of a page into lines from top to bottom. Glyphs are joined
+// with a space where the gap between them is wider than a fraction of the font size, since
+// PDFs often position words instead of drawing space characters.
+func pdfPageLines(page pdf.Page) []string {
+	texts := page.Content().Text
+	sort.SliceStable(texts, func(i, j int) bool { return texts[i].Y > texts[j].Y })
+
+	var lines []string
+	flush := func(glyphs []pdf.Text) {
+		sort.SliceStable(glyphs, func(i, j int) bool { return glyphs[i].X < glyphs[j].X })
+		var b strings.Builder
+		for i, g := range glyphs {
+			if i > 0 {
+				prev := glyphs[i-1]
+				if g.X-(prev.X+prev.W) > math.Max(prev.FontSize, 1)*0.25 {
+					b.WriteByte(' ')
+				}
+			}
+			b.WriteString(g.S)
+		}
+		if line := strings.Join(strings.Fields(b.String()), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	var current []pdf.Text
+	for _, t := range texts {
+		if len(current) > 0 && math.Abs(current[0].Y-t.Y) > math.Max(current[0].FontSize, 1)*0.5 {
+			flush(current)
+			current = nil
+		}
+		current = append(current, t)
+	}
+	if len(current) > 0 {
+		flush(current)
+	}
+	return lines
+}
+
+// pdfJPEG is a JPEG image stream found in the raw bytes of a PDF.
+type pdfJPEG struct {
+	width, height int
+	data          []byte
+	used          bool
+}
+
+var (
+	pdfStreamPattern    = regexp.MustCompile(`<<((?:[^<>]|<<[^<>]*>>)*)>>\s*stream\r?\n`)
+	pdfDimensionPattern = regexp.MustCompile(`/(Width|Height)\s+(\d+)`)
+)
+
+// pdfJPEGStreams finds the DCTDecode image streams of a PDF. The PDF library cannot decode
+// them, but their content is a JPEG file that the Vision API reads as is.
+func pdfJPEGStreams(data []byte) []*pdfJPEG {
+	var jpegs []*pdfJPEG
+	for _, m := range pdfStreamPattern.FindAllSubmatchIndex(data, -1) {
+		dict := data[m[2]:m[3]]
+		if !bytes.Contains(dict, []byte("/DCTDecode")) || !bytes.Contains(dict, []byte("/Image")) {
+			continue
+		}
+		end := bytes.Index(data[m[1]:], []byte("endstream"))
+		if end < 0 {
+			continue
+		}
+		jpeg := &pdfJPEG{data: bytes.TrimRight(data[m[1]:m[1]+end], "\r\n")}
+		for _, dim := range pdfDimensionPattern.FindAllSubmatch(dict, -1) {
+			n, _ := strconv.Atoi(string(dim[2]))
+			if string(dim[1]) == "Width" {
+				jpeg.width = n
+			} else {
+				jpeg.height = n
+			}
+		}
+		jpegs = append(jpegs, jpeg)
+	}
+	return jpegs
+}
+
+// pdfPageImages returns the images drawn on a page, largest first. JPEG images are taken from
+// the raw streams with the same dimensions; uncompressed or Flate-compressed RGB and gray
+// images are converted to PNG.
+func pdfPageImages(page pdf.Page, jpegs []*pdfJPEG) [][]byte {
+	type pageImage struct {
+		area int
+		data []byte
+	}
+	var images []pageImage
+
+	xobjects := page.Resources().Key("XObject")
+	for _, name := range xobjects.Keys() {
+		x := xobjects.Key(name)
+		if x.Key("Subtype").Name() != "Image" {
+			continue
+		}
+		width, height := int(x.Key("Width").Int64()), int(x.Key("Height").Int64())
+
+		filter := x.Key("Filter")
+		if filter.Kind() == pdf.Array && filter.Len() == 1 {
+			filter = filter.Index(0)
+		}
+
+		var data []byte
+		switch filter.Name() {
+		case "DCTDecode":
+			for _, j := range jpegs {
+				if !j.used && j.width == width && j.height == height {
+					j.used = true
+					data = j.data
+					break
+				}
+			}
+		case "", "FlateDecode":
+			data = pdfImagePNG(x, width, height)
+		}
+		if data != nil {
+			images = append(images, pageImage{area: width * height, data: data})
+		}
+	}
+
+	sort.SliceStable(images, func(i, j int) bool { return images[i].area > images[j].area })
+	result := make([][]byte, len(images))
+	for i, img := range images {
+		result[i] = img.data
+	}
+	return result
+}
+
+// pdfImagePNG encodes the decoded samples of an 8-bit RGB or gray image XObject as PNG. It
+// returns nil for other color spaces and for data that cannot be decoded.
+func pdfImagePNG(x pdf.Value, width, height int) (result []byte) {
+	defer func() {
+		if recover() != nil {
+			result = nil
+		}
+	}()
+
+	channels := 0
+	switch x.Key("ColorSpace").Name() {
+	case "DeviceRGB":
+		channels = 3
+	case "DeviceGray":
+		channels = 1
+	}
+	if channels == 0 || x.Key("BitsPerComponent").Int64() != 8 || width <= 0 || height <= 0 {
+		return nil
+	}
+
+	rc := x.Reader()
+	defer rc.Close()
+	samples, err := io.ReadAll(rc)
+	if err != nil || len(samples) < width*height*channels {
+		return nil
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for py := 0; py < height; py++ {
+		for px := 0; px < width; px++ {
+			s := samples[(py*width+px)*channels:]
+			if channels == 1 {
+				img.Set(px, py, color.Gray{Y: s[0]})
+			} else {
+				img.Set(px, py, color.RGBA{R: s[0], G: s[1], B: s[2], A: 255})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+func init() {
+	Register(pdfParser{})
+}
+
+type pdfParser struct{}
+
+func (pdfParser) Name() string { return "PDF" }
+
+func (pdfParser) usesProfile() {}
+
+func (pdfParser) usesVision() {}
+
+func (pdfParser) Detect(filename string, head []byte) int {
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return 100
+	case hasExtension(filename, ".pdf"):
+		return 50
+	}
+	return 0
+}
+
+func (pdfParser) Parse(r io.Reader, opts Options) ([]Statement, error) {
+	txs, diags, err := ParsePDF(r, opts)
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, diags), nil
+}
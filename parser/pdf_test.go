@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"firefly-importer/models"
+)
+
+// buildPDF assembles a PDF whose pages draw the given lines of text in Helvetica, one line per
+// text object from the top down. A nil page draws an 8x8 gray image instead of text.
+func buildPDF(pages [][]string) []byte {
+	var objects []string
+	add := func(obj string) int {
+		objects = append(objects, obj)
+		return len(objects)
+	}
+
+	add("") // catalog, filled in below
+	add("") // page tree
+	font := add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+
+	var kids []string
+	for _, lines := range pages {
+		var content, resources string
+		if lines == nil {
+			img := add(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width 8 /Height 8 /ColorSpace /DeviceGray /BitsPerComponent 8 /Length 64 >>\nstream\n%s\nendstream",
+				strings.Repeat("\x80", 64)))
+			content = "q 200 0 0 200 50 500 cm /Im1 Do Q"
+			resources = fmt.Sprintf("<< /XObject << /Im1 %d 0 R >> >>", img)
+		} else {
+			var b strings.Builder
+			for i, line := range lines {
+				fmt.Fprintf(&b, "BT /F1 10 Tf 50 %d Td (%s) Tj ET\n", 750-i*14, line)
+			}
+			content = b.String()
+			resources = fmt.Sprintf("<< /Font << /F1 %d 0 R >> >>", font)
+		}
+		stream := add(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+		page := add(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources %s /Contents %d 0 R >>", resources, stream))
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+	objects[0] = "<< /Type /Catalog /Pages 2 0 R >>"
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestParsePDF(t *testing.T) {
+	data := buildPDF([][]string{
+		{
+			"Example Bank - Statement February 2025",
+			"Date Description Amount Balance",
+			"03.02.2025 Coffee Shop -3,50 1.246,50",
+			"05.02.2025 Salary ACME 2.500,00 3.746,50",
+		},
+		{
+			"31.02.2025 Bad Date -1,00",
+			"Closing balance 3.746,50",
+		},
+	})
+
+	txs, diags, err := ParsePDF(bytes.NewReader(data), Options{Profile: DefaultProfile})
+	if err != nil {
+		t.Fatalf("ParsePDF failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+	if txs[0].Date != "2025-02-03" || txs[0].Description != "Coffee Shop" || txs[0].Amount != 3.5 || txs[0].Type != "withdrawal" {
+		t.Errorf("Unexpected first transaction: %+v", txs[0])
+	}
+	if txs[1].Amount != 2500 || txs[1].Type != "deposit" {
+		t.Errorf("Expected deposit of 2500, got %+v", txs[1])
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d", len(diags))
+	}
+	if diags[0].Line != 5 || diags[0].Raw != "31.02.2025 Bad Date -1,00" {
+		t.Errorf("Expected diagnostic for line 5, got %+v", diags[0])
+	}
+}
+
+func TestParsePDFLinePattern(t *testing.T) {
+	data := buildPDF([][]string{{
+		"2025-02-03 Coffee Shop 3.50 DR",
+		"2025-02-05 Salary 2,500.00 CR",
+	}})
+
+	profile := DefaultProfile
+	profile.LinePattern = `^(?P<date>\d{4}-\d{2}-\d{2}) (?P<description>.+?) (?P<amount>[\d,]+\.\d{2}) (?P<type>DR|CR)$`
+	if err := ValidateProfile(profile); err != nil {
+		t.Fatalf("ValidateProfile failed: %v", err)
+	}
+
+	txs, _, err := ParsePDF(bytes.NewReader(data), Options{Profile: profile})
+	if err != nil {
+		t.Fatalf("ParsePDF failed: %v", err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+	if txs[0].Type != "withdrawal" || txs[0].Amount != 3.5 {
+		t.Errorf("Expected withdrawal of 3.50, got %+v", txs[0])
+	}
+	if txs[1].Type != "deposit" || txs[1].Amount != 2500 {
+		t.Errorf("Expected deposit of 2500, got %+v", txs[1])
+	}
+}
+
+func TestValidateProfileLinePattern(t *testing.T) {
+	for _, pattern := range []string{
+		`(?P<date>\S+) (?P<amount>\S+)`,
+		`(?P<date>\S+) (?P<description>.+) (?P<debit>\S+)`,
+		`(?P<date>\S+ (`,
+	} {
+		profile := DefaultProfile
+		profile.LinePattern = pattern
+		if err := ValidateProfile(profile); err == nil {
+			t.Errorf("Expected an error for line pattern %q", pattern)
+		}
+	}
+}
+
+func TestParsePDFScannedPage(t *testing.T) {
+	data := buildPDF([][]string{nil})
+
+	if _, _, err := ParsePDF(bytes.NewReader(data), Options{Profile: DefaultProfile}); err == nil {
+		t.Error("Expected an error for a scanned PDF without a vision API")
+	}
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content []struct {
+					ImageURL map[string]string `json:"image_url"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if url := req.Messages[0].Content[1].ImageURL["url"]; !strings.HasPrefix(url, "data:image/png;base64,") {
+			t.Errorf("Expected a PNG page image, got %.40s", url)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"message":{"content":"[{\"date\":\"2025-02-03\",\"description\":\"Bakery\",\"amount\":2.10,\"type\":\"withdrawal\"}]"}}]}`)
+	}))
+	defer mockServer.Close()
+
+	opts := Options{Profile: DefaultProfile, FileDate: "2025-02-28", Vision: VisionConfig{APIURL: mockServer.URL}}
+	txs, diags, err := ParsePDF(bytes.NewReader(data), opts)
+	if err != nil {
+		t.Fatalf("ParsePDF failed: %v", err)
+	}
+	if len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %+v", diags)
+	}
+	if len(txs) != 1 || txs[0].Description != "Bakery" || txs[0].Status != models.StatusPending {
+		t.Errorf("Expected the vision transaction, got %+v", txs)
+	}
+}
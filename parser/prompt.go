@@ -0,0 +1,144 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"firefly-importer/models"
+)
+
+// PromptData holds the variables available to prompt templates.
+type PromptData struct {
+	Today      string   // YYYY-MM-DD; the file date when known
+	Year       string   // Year assumed for dates without one
+	Account    string   // Name of the Firefly account imported into
+	Currency   string   // Currency code of that account
+	Categories []string // Firefly category names
+}
+
+// The built-in prompts are used when no template is selected. Raise their version whenever
+// their text changes, so that cached vision results of the old text are not reused.
+var (
+	DefaultStatementPrompt = models.PromptTemplate{
+		Name:    "default-statement",
+		Kind:    models.PromptKindStatement,
+		Version: 2,
+		Text: `Extract bank transactions from this image{{if .Account}} of the account "{{.Account}}"{{end}}. Return ONLY a JSON object
+{"transactions": [...]} whose array holds one object per transaction, containing:
+"date" (YYYY-MM-DD), "description" (string), "amount" (float, absolute value), "type" (string: "withdrawal" or "deposit"),
+"confidence" (object with "date", "description", "amount" and "type", each a number from 0 to 1 saying how sure you are of that field),
+and "box" (the transaction's row in the image as {"x", "y", "width", "height"} in fractions of the image size from the top left, or null).
+Description should only contain transaction title, not the full transaction details.
+Give a low date confidence when the year is assumed, and low confidences for text that is blurry, cut off or hard to read.
+{{if .Currency}}Amounts are in {{.Currency}}.
+{{end}}Assume the year is {{.Year}} if not provided in the image.
+Today's date is {{.Today}}, use this to resolve relative dates like "today" or "yesterday".
+Do not include markdown blocks like ` + "```json" + `, ` + "```" + `, or any other text.`,
+	}
+
+	DefaultReceiptPrompt = models.PromptTemplate{
+		Name:    "default-receipt",
+		Kind:    models.PromptKindReceipt,
+		Version: 1,
+		Text: `Extract the purchase from this shop receipt. Return ONLY a JSON object with:
+"merchant" (shop name), "date" (YYYY-MM-DD), "total" (float, the amount paid), "tax" (float, the tax on the receipt, 0 if not shown),
+and "items" (array of objects with "description" (string, short item name), "amount" (float, the line total, negative for discounts)
+and "category" (string)).
+{{if .Categories}}For "category" choose the best match from this list, or "" if none fits: {{join .Categories ", "}}.
+{{else}}For "category" give a short spending category such as "Groceries" or "Household".
+{{end}}Assume the year is {{.Year}} if not provided in the image.
+Today's date is {{.Today}}, use this to resolve relative dates like "today" or "yesterday".
+Do not include markdown blocks like ` + "```json" + `, ` + "```" + `, or any other text.`,
+	}
+)
+
+// promptFor returns the prompt template to use for a kind of extraction: the configured one
+// when it is written for that kind, otherwise the built-in one.
+func promptFor(cfg VisionConfig, kind string) models.PromptTemplate {
+	if cfg.Prompt.Text != "" && cfg.Prompt.Kind == kind {
+		return cfg.Prompt
+	}
+	if kind == models.PromptKindReceipt {
+		return DefaultReceiptPrompt
+	}
+	return DefaultStatementPrompt
+}
+
+// RenderPrompt fills in the variables of a prompt template.
+func RenderPrompt(prompt models.PromptTemplate, data PromptData) (string, error) {
+	tmpl, err := template.New(prompt.Name).Funcs(template.FuncMap{"join": strings.Join}).Parse(prompt.Text)
+	if err != nil {
+		return "", fmt.Errorf("invalid prompt template %q: %w", prompt.Name, err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt template %q: %w", prompt.Name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// ValidatePrompt checks that a prompt template has a name and kind and renders without errors.
+func ValidatePrompt(prompt models.PromptTemplate) error {
+	switch {
+	case strings.TrimSpace(prompt.Name) == "":
+		return errors.New("prompt name is required")
+	case prompt.Name == DefaultStatementPrompt.Name || prompt.Name == DefaultReceiptPrompt.Name:
+		return fmt.Errorf("%q is the name of a built-in prompt", prompt.Name)
+	case prompt.Kind != models.PromptKindStatement && prompt.Kind != models.PromptKindReceipt:
+		return fmt.Errorf("unknown prompt kind %q", prompt.Kind)
+	case strings.TrimSpace(prompt.Text) == "":
+		return errors.New("prompt text is required")
+	}
+	_, err := RenderPrompt(prompt, PromptData{
+		Today:      "2025-01-31",
+		Year:       "2025",
+		Account:    "Checking",
+		Currency:   "EUR",
+		Categories: []string{"Groceries"},
+	})
+	return err
+}
+
+// promptKindComment finds the kind of a prompt file in a leading {{/* kind: receipt */}}
+// template comment.
+var promptKindComment = regexp.MustCompile(`^\s*\{\{/\*\s*kind:\s*(\w+)\s*\*/\}\}\s*`)
+
+// LoadPromptDir reads the prompt templates in the *.tmpl files of a directory. A template is
+// named after its file, and is a statement prompt unless it starts with {{/* kind: receipt */}}.
+// Versions are left at 0, to be assigned when the templates are saved.
+func LoadPromptDir(dir string) ([]models.PromptTemplate, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prompt directory: %w", err)
+	}
+
+	var prompts []models.PromptTemplate
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt file: %w", err)
+		}
+
+		prompt := models.PromptTemplate{
+			Name: strings.TrimSuffix(filepath.Base(path), ".tmpl"),
+			Kind: models.PromptKindStatement,
+			Text: string(data),
+		}
+		if m := promptKindComment.FindStringSubmatch(prompt.Text); m != nil {
+			prompt.Kind = strings.ToLower(m[1])
+			prompt.Text = prompt.Text[len(m[0]):]
+		}
+		prompt.Text = strings.TrimSpace(prompt.Text)
+
+		if err := ValidatePrompt(prompt); err != nil {
+			return nil, fmt.Errorf("prompt file %s: %w", filepath.Base(path), err)
+		}
+		prompts = append(prompts, prompt)
+	}
+	return prompts, nil
+}
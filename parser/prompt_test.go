@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"firefly-importer/models"
+)
+
+func TestRenderPrompt(t *testing.T) {
+	prompt := models.PromptTemplate{
+		Name: "bank-app",
+		Kind: models.PromptKindStatement,
+		Text: `Read {{.Account}} in {{.Currency}} on {{.Today}} ({{.Year}}); categories: {{join .Categories ", "}}`,
+	}
+	got, err := RenderPrompt(prompt, PromptData{
+		Today:      "2025-03-01",
+		Year:       "2025",
+		Account:    "Checking",
+		Currency:   "EUR",
+		Categories: []string{"Groceries", "Rent"},
+	})
+	if err != nil {
+		t.Fatalf("RenderPrompt failed: %v", err)
+	}
+	if want := "Read Checking in EUR on 2025-03-01 (2025); categories: Groceries, Rent"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	for _, p := range []models.PromptTemplate{DefaultStatementPrompt, DefaultReceiptPrompt} {
+		if _, err := RenderPrompt(p, PromptData{}); err != nil {
+			t.Errorf("Built-in prompt %s failed to render: %v", p.Name, err)
+		}
+	}
+}
+
+func TestValidatePrompt(t *testing.T) {
+	valid := models.PromptTemplate{Name: "bank-app", Kind: models.PromptKindReceipt, Text: "Read the receipt of {{.Today}}"}
+	if err := ValidatePrompt(valid); err != nil {
+		t.Errorf("Expected a valid prompt, got %v", err)
+	}
+
+	for _, p := range []models.PromptTemplate{
+		{Name: "", Kind: models.PromptKindStatement, Text: "Read"},
+		{Name: DefaultStatementPrompt.Name, Kind: models.PromptKindStatement, Text: "Read"},
+		{Name: "bank-app", Kind: "invoice", Text: "Read"},
+		{Name: "bank-app", Kind: models.PromptKindStatement, Text: "Read {{.Today"},
+		{Name: "bank-app", Kind: models.PromptKindStatement, Text: "Read {{.Balance}}"},
+	} {
+		if err := ValidatePrompt(p); err == nil {
+			t.Errorf("Expected an error for prompt %+v", p)
+		}
+	}
+}
+
+func TestLoadPromptDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "bank-app.tmpl"), []byte("Read the list of {{.Account}}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "shop.tmpl"), []byte("{{/* kind: receipt */}}\nRead the receipt"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a prompt"), 0o644)
+
+	prompts, err := LoadPromptDir(dir)
+	if err != nil {
+		t.Fatalf("LoadPromptDir failed: %v", err)
+	}
+	if len(prompts) != 2 {
+		t.Fatalf("Expected 2 prompts, got %+v", prompts)
+	}
+	if prompts[0].Name != "bank-app" || prompts[0].Kind != models.PromptKindStatement || prompts[0].Text != "Read the list of {{.Account}}" {
+		t.Errorf("Unexpected statement prompt: %+v", prompts[0])
+	}
+	if prompts[1].Name != "shop" || prompts[1].Kind != models.PromptKindReceipt || prompts[1].Text != "Read the receipt" {
+		t.Errorf("Unexpected receipt prompt: %+v", prompts[1])
+	}
+
+	os.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("{{if}}"), 0o644)
+	if _, err := LoadPromptDir(dir); err == nil {
+		t.Error("Expected an error for a broken template")
+	}
+}
+
+func TestParseImagePromptTemplate(t *testing.T) {
+	var prompt string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Messages []struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[0].Content[0].Text
+		visionReply(w, `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]`)
+	}))
+	defer mockServer.Close()
+
+	cfg := VisionConfig{
+		APIURL:   mockServer.URL,
+		Account:  "Checking",
+		Currency: "EUR",
+		Prompt:   models.PromptTemplate{Name: "bank-app", Kind: models.PromptKindStatement, Version: 3, Text: "List {{.Account}} in {{.Currency}}"},
+	}
+	txs, err := ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+	if err != nil {
+		t.Fatalf("ParseImageWithConfig failed: %v", err)
+	}
+	if prompt != "List Checking in EUR" {
+		t.Errorf("Expected the rendered template as prompt, got %q", prompt)
+	}
+	if e := txs[0].Extraction; e == nil || e.PromptName != "bank-app" || e.PromptVersion != 3 {
+		t.Errorf("Expected the prompt version to be recorded, got %+v", e)
+	}
+
+	// A receipt template does not apply to statements
+	cfg.Prompt.Kind = models.PromptKindReceipt
+	txs, _ = ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+	if !strings.Contains(prompt, "Extract bank transactions") || txs[0].Extraction.PromptName != DefaultStatementPrompt.Name {
+		t.Errorf("Expected the built-in statement prompt, got %q", prompt)
+	}
+}
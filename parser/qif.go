@@ -0,0 +1,258 @@
+package parser
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"firefly-importer/models"
+)
+
+// qifDatePattern splits a QIF date into its three numeric parts. Quicken writes US dates as
+// M/D/YY, M/D'YY or M/D'YYYY (padding single digits with spaces, as in " 1/ 5' 4");
+// European exports use D/M/YYYY or D.M.YYYY.
+var qifDatePattern = regexp.MustCompile(`^(\d{1,4})\s*[/.\-]\s*(\d{1,2})\s*([/.\-'])\s*(\d{1,4})$`)
+
+// qifSupportedTypes are the account sections whose records are imported.
+var qifSupportedTypes = map[string]bool{
+	"bank":  true,
+	"ccard": true,
+	"cash":  true,
+}
+
+type qifRecord struct {
+	date     string
+	amount   string
+	payee    string
+	memo     string
+	category string
+	line     int      // Line of the first field
+	raw      []string // Lines of the record, for diagnostics
+}
+
+// ParseQIF reads a Quicken Interchange Format file and maps the records of its
+// !Type:Bank and !Type:CCard sections to transactions. The QIF category becomes the
+// SuggestedCategory. Whether dates are month-first (US) or day-first (European) is
+// decided once for the whole file. Records without a usable date or amount are returned as
+// diagnostics.
+func ParseQIF(r io.Reader) ([]models.Transaction, []Diagnostic, error) {
+	var records []qifRecord
+	var current qifRecord
+	inSection := false
+	sawHeader := false
+	lineNo := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			sawHeader = true
+			header := strings.ToLower(line)
+			if strings.HasPrefix(header, "!type:") {
+				inSection = qifSupportedTypes[strings.TrimSpace(strings.TrimPrefix(header, "!type:"))]
+			} else if strings.HasPrefix(header, "!account") {
+				inSection = false // Account list records describe accounts, not transactions
+			}
+			current = qifRecord{}
+			continue
+		}
+
+		if line == "^" {
+			if inSection && current.raw != nil {
+				records = append(records, current)
+			}
+			current = qifRecord{}
+			continue
+		}
+
+		if current.raw == nil {
+			current.line = lineNo
+		}
+		current.raw = append(current.raw, line)
+
+		value := strings.TrimSpace(line[1:])
+		switch line[0] {
+		case 'D':
+			current.date = value
+		case 'T', 'U':
+			current.amount = value
+		case 'P':
+			current.payee = value
+		case 'M':
+			current.memo = value
+		case 'L':
+			current.category = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read qif file: %w", err)
+	}
+	// Some exporters leave out the ^ after the last record
+	if inSection && current.raw != nil {
+		records = append(records, current)
+	}
+	if !sawHeader {
+		return nil, nil, errors.New("no QIF !Type header found")
+	}
+
+	dayFirst := qifDayFirst(records)
+
+	var transactions []models.Transaction
+	var diagnostics []Diagnostic
+	for _, rec := range records {
+		tx, err := rec.toTransaction(dayFirst)
+		if err != nil {
+			diagnostics = append(diagnostics, Diagnostic{
+				Line:        rec.line,
+				Raw:         strings.Join(rec.raw, "\n"),
+				Reason:      err.Error(),
+				Transaction: tx,
+			})
+			continue
+		}
+		transactions = append(transactions, tx)
+	}
+
+	return transactions, diagnostics, nil
+}
+
+// toTransaction maps a record onto a transaction. On error the returned transaction holds the
+// fields that could be read.
+func (rec qifRecord) toTransaction(dayFirst bool) (models.Transaction, error) {
+	description := rec.payee
+	if description == "" {
+		description = rec.memo
+	}
+
+	category := rec.category
+	if strings.HasPrefix(category, "[") {
+		category = "" // [Account] marks a transfer, not a category
+	}
+
+	tx := models.Transaction{
+		Date:                rec.date,
+		Description:         description,
+		OriginalDescription: description,
+		SuggestedCategory:   category,
+		Status:              models.StatusPending,
+	}
+
+	date, err := parseQIFDate(rec.date, dayFirst)
+	if err != nil {
+		return tx, err
+	}
+	tx.Date = date
+
+	amount, err := parseQIFAmount(rec.amount)
+	if err != nil {
+		return tx, err
+	}
+
+	tx.Type = "deposit"
+	if amount < 0 {
+		tx.Type = "withdrawal"
+		amount = -amount
+	}
+	tx.Amount = amount
+
+	return tx, nil
+}
+
+// qifDayFirst decides the date convention for a file. A first part above 12 can only be a day
+// and a second part above 12 can only be a day in US order; dotted dates are European.
+// Files that stay ambiguous fall back to the US convention Quicken uses.
+func qifDayFirst(records []qifRecord) bool {
+	for _, rec := range records {
+		m := qifDatePattern.FindStringSubmatch(rec.date)
+		if m == nil || len(m[1]) == 4 {
+			continue
+		}
+		first, _ := strconv.Atoi(m[1])
+		second, _ := strconv.Atoi(m[2])
+		if first > 12 || strings.Contains(rec.date, ".") {
+			return true
+		}
+		if second > 12 {
+			return false
+		}
+	}
+	return false
+}
+
+func parseQIFDate(v string, dayFirst bool) (string, error) {
+	m := qifDatePattern.FindStringSubmatch(v)
+	if m == nil {
+		return "", fmt.Errorf("invalid qif date %q", v)
+	}
+
+	first, _ := strconv.Atoi(m[1])
+	second, _ := strconv.Atoi(m[2])
+	year, _ := strconv.Atoi(m[4])
+
+	var day, month int
+	switch {
+	case len(m[1]) == 4: // YYYY-MM-DD
+		year, month, day = first, second, year
+	case dayFirst:
+		day, month = first, second
+	default:
+		month, day = first, second
+	}
+
+	if len(m[4]) <= 2 && len(m[1]) != 4 {
+		// An apostrophe separator marks years from 2000 onwards
+		if strings.Contains(m[3], "'") || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day || int(t.Month()) != month {
+		return "", fmt.Errorf("invalid qif date %q", v)
+	}
+	return t.Format("2006-01-02"), nil
+}
+
+func parseQIFAmount(v string) (float64, error) {
+	return ParseAmount(v, "")
+}
+
+func init() {
+	Register(qifParser{})
+}
+
+type qifParser struct{}
+
+func (qifParser) Name() string { return "QIF" }
+
+func (qifParser) Detect(filename string, head []byte) int {
+	text := strings.ToLower(textHead(head))
+	if strings.HasPrefix(text, "!type:") || strings.HasPrefix(text, "!account") || strings.HasPrefix(text, "!option") {
+		return 100
+	}
+	if hasExtension(filename, ".qif") {
+		return 50
+	}
+	return 0
+}
+
+func (qifParser) Parse(r io.Reader, _ Options) ([]Statement, error) {
+	txs, diags, err := ParseQIF(r)
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, diags), nil
+}
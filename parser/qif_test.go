@@ -0,0 +1,128 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseQIFUS(t *testing.T) {
+	qifData := `!Type:Bank
+D10/ 1'23
+T-45.50
+PGrocery Store
+MWeekly shopping
+LFood:Groceries
+^
+D10/15/2023
+T1,500.00
+PACME Payroll
+LSalary
+^
+D10/16/2023
+T-200.00
+PTransfer to savings
+L[Savings]
+^
+D10/18/2023
+TN/A
+PUnreadable amount
+^
+!Type:Invst
+D10/17/2023
+T-99.00
+PBroker
+^`
+
+	txs, diags, err := ParseQIF(strings.NewReader(qifData))
+	if err != nil {
+		t.Fatalf("ParseQIF failed: %v", err)
+	}
+
+	if len(txs) != 3 {
+		t.Fatalf("Expected 3 transactions, got %d", len(txs))
+	}
+
+	if txs[0].Date != "2023-10-01" {
+		t.Errorf("Expected Date 2023-10-01, got %s", txs[0].Date)
+	}
+	if txs[0].Description != "Grocery Store" {
+		t.Errorf("Expected Description Grocery Store, got %s", txs[0].Description)
+	}
+	if txs[0].Amount != 45.50 || txs[0].Type != "withdrawal" {
+		t.Errorf("Expected withdrawal of 45.50, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+	if txs[0].SuggestedCategory != "Food:Groceries" {
+		t.Errorf("Expected SuggestedCategory Food:Groceries, got %s", txs[0].SuggestedCategory)
+	}
+
+	if txs[1].Date != "2023-10-15" || txs[1].Amount != 1500.00 || txs[1].Type != "deposit" {
+		t.Errorf("Unexpected second transaction: %+v", txs[1])
+	}
+
+	if txs[2].SuggestedCategory != "" {
+		t.Errorf("Expected transfer category to be dropped, got %s", txs[2].SuggestedCategory)
+	}
+
+	if len(diags) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %d", len(diags))
+	}
+	if diags[0].Line != 18 || diags[0].Transaction.Date != "2023-10-18" || diags[0].Transaction.Description != "Unreadable amount" {
+		t.Errorf("Unexpected diagnostic: %+v", diags[0])
+	}
+}
+
+func TestParseQIFEuropean(t *testing.T) {
+	qifData := `!Type:CCard
+D05/10/2023
+T-12.00
+PBakery
+^
+D25/10/2023
+T-30.00
+MFuel
+^`
+
+	txs, _, err := ParseQIF(strings.NewReader(qifData))
+	if err != nil {
+		t.Fatalf("ParseQIF failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+
+	// 25/10 can only be day-first, so 05/10 is the 5th of October as well
+	if txs[0].Date != "2023-10-05" {
+		t.Errorf("Expected Date 2023-10-05, got %s", txs[0].Date)
+	}
+	if txs[1].Date != "2023-10-25" {
+		t.Errorf("Expected Date 2023-10-25, got %s", txs[1].Date)
+	}
+	if txs[1].Description != "Fuel" {
+		t.Errorf("Expected Description to fall back to memo, got %s", txs[1].Description)
+	}
+}
+
+func TestParseQIFWithoutTrailingSeparator(t *testing.T) {
+	qifData := `!Type:Bank
+D01/15/2024
+T-42.50
+PGrocery Store
+^
+D01/16/2024
+T1000.00
+PSalary
+`
+
+	txs, _, err := ParseQIF(strings.NewReader(qifData))
+	if err != nil {
+		t.Fatalf("ParseQIF failed: %v", err)
+	}
+
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+	if txs[1].Description != "Salary" || txs[1].Amount != 1000.00 {
+		t.Errorf("Expected the last record to be kept, got %+v", txs[1])
+	}
+}
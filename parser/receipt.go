@@ -0,0 +1,223 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"firefly-importer/models"
+)
+
+// receiptSchema describes the vision answer for a shop receipt.
+var receiptSchema = JSONSchema{
+	Name: "receipt",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"merchant": map[string]any{"type": "string"},
+			"date":     map[string]any{"type": "string", "description": "YYYY-MM-DD"},
+			"total":    map[string]any{"type": "number", "description": "Amount paid"},
+			"tax":      map[string]any{"type": "number", "description": "0 if not shown"},
+			"items": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"description": map[string]any{"type": "string"},
+						"amount":      map[string]any{"type": "number", "description": "Line total; negative for discounts"},
+						"category":    map[string]any{"type": "string"},
+					},
+					"required":             []string{"description", "amount", "category"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"merchant", "date", "total", "tax", "items"},
+		"additionalProperties": false,
+	},
+}
+
+// receiptAnswer is a receipt as answered by the model.
+type receiptAnswer struct {
+	Merchant string  `json:"merchant"`
+	Date     string  `json:"date"`
+	Total    float64 `json:"total"`
+	Tax      float64 `json:"tax"`
+	Items    []struct {
+		Description string  `json:"description"`
+		Amount      float64 `json:"amount"`
+		Category    string  `json:"category"`
+	} `json:"items"`
+}
+
+// ParseReceipt sends a photo or scan of a shop receipt to a Vision API and reads it as one
+// withdrawal from the merchant. When the receipt lists several items the transaction is split
+// into one part per item, each with a suggested category from cfg.Categories, so that the parts can
+// be booked on different budgets. Tall receipts are sent as consecutive tiles in one request.
+func ParseReceipt(r io.Reader, fileDate string, cfg VisionConfig) ([]models.Transaction, error) {
+	if cfg.APIURL == "" {
+		return nil, errors.New("vision API URL is required")
+	}
+
+	imageBytes, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	promptTemplate := promptFor(cfg, models.PromptKindReceipt)
+	prompt, err := RenderPrompt(promptTemplate, promptData(fileDate, cfg))
+	if err != nil {
+		return nil, err
+	}
+	cacheKey := VisionCacheKey(imageBytes, cfg, promptTemplate, prompt)
+	if transactions, ok := cachedVisionResult(cfg, cacheKey); ok {
+		return transactions, nil
+	}
+
+	tiles, err := prepareVisionImages(imageBytes, cfg)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]VisionImage, len(tiles))
+	for i, tile := range tiles {
+		images[i] = tile.VisionImage
+	}
+
+	if len(images) > 1 {
+		prompt += "\nThe images are consecutive parts of one long receipt, from top to bottom."
+	}
+
+	provider, err := NewVisionProvider(cfg.Provider, cfg.APIURL, cfg.APIKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt receiptAnswer
+	req := VisionRequest{
+		Model:    cfg.Model,
+		Messages: []VisionMessage{{Role: "user", Text: prompt, Images: images}},
+		Schema:   &receiptSchema,
+	}
+	err = askVision(provider, req, func(content string) (err error) {
+		receipt, err = decodeReceipt(content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tx := models.Transaction{
+		Date:                receipt.Date,
+		Description:         receipt.Merchant,
+		OriginalDescription: receipt.Merchant,
+		Amount:              math.Abs(receipt.Total),
+		Type:                "withdrawal",
+		Status:              models.StatusPending,
+		Splits:              receiptSplits(receipt, cfg.Categories),
+		Extraction:          &models.Extraction{PromptName: promptTemplate.Name, PromptVersion: promptTemplate.Version},
+	}
+	if date, err := ParseDate(tx.Date, ""); err == nil {
+		tx.Date = date
+	}
+
+	transactions := []models.Transaction{tx}
+	saveVisionResult(cfg, cacheKey, transactions)
+	return transactions, nil
+}
+
+// decodeReceipt reads a receipt from a model answer and checks that it is complete.
+func decodeReceipt(content string) (receiptAnswer, error) {
+	var receipt receiptAnswer
+	raw, err := extractJSON(content)
+	if err != nil {
+		return receipt, err
+	}
+	if err := json.Unmarshal(raw, &receipt); err != nil {
+		return receipt, err
+	}
+
+	switch {
+	case strings.TrimSpace(receipt.Merchant) == "":
+		return receipt, errors.New("the receipt has no merchant")
+	case strings.TrimSpace(receipt.Date) == "":
+		return receipt, errors.New("the receipt has no date")
+	case receipt.Total == 0:
+		return receipt, errors.New("the receipt has no total")
+	}
+	for i, item := range receipt.Items {
+		if strings.TrimSpace(item.Description) == "" {
+			return receipt, fmt.Errorf("item %d has no description", i+1)
+		}
+	}
+	return receipt, nil
+}
+
+// receiptSplits turns the items of a receipt into the parts of a split transaction. Discounts
+// are taken off the item before them, and whatever the items do not cover, usually tax added at
+// the till, becomes a part of its own. It returns nil when the receipt cannot be split into
+// positive parts adding up to the total, or has only one part.
+func receiptSplits(receipt receiptAnswer, categories []string) []models.Split {
+	var splits []models.Split
+	for _, item := range receipt.Items {
+		amount := cents(item.Amount)
+		if amount < 0 {
+			if len(splits) == 0 || splits[len(splits)-1].Amount+amount <= 0 {
+				return nil
+			}
+			splits[len(splits)-1].Amount = cents(splits[len(splits)-1].Amount + amount)
+			continue
+		}
+		if amount == 0 {
+			continue
+		}
+		splits = append(splits, models.Split{
+			Description:       strings.TrimSpace(item.Description),
+			Amount:            amount,
+			SuggestedCategory: matchCategory(item.Category, categories),
+		})
+	}
+
+	total := 0.0
+	for _, split := range splits {
+		total += split.Amount
+	}
+	remainder := cents(math.Abs(receipt.Total) - total)
+	switch {
+	case remainder < 0:
+		return nil
+	case remainder > 0:
+		description := "Other"
+		if tax := cents(receipt.Tax); tax > 0 && remainder == tax {
+			description = "Tax"
+		}
+		splits = append(splits, models.Split{Description: description, Amount: remainder})
+	}
+
+	if len(splits) < 2 {
+		return nil
+	}
+	return splits
+}
+
+// matchCategory returns the name in categories matching a suggested category regardless of
+// case, or the suggestion itself when there are no categories to choose from.
+func matchCategory(suggested string, categories []string) string {
+	suggested = strings.TrimSpace(suggested)
+	if len(categories) == 0 {
+		return suggested
+	}
+	for _, name := range categories {
+		if strings.EqualFold(name, suggested) {
+			return name
+		}
+	}
+	return ""
+}
+
+// cents rounds an amount to two decimals.
+func cents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseReceipt(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResponseFormat struct {
+				JSONSchema struct {
+					Name string `json:"name"`
+				} `json:"json_schema"`
+			} `json:"response_format"`
+			Messages []struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.ResponseFormat.JSONSchema.Name != "receipt" {
+			t.Errorf("Expected the receipt schema, got %q", req.ResponseFormat.JSONSchema.Name)
+		}
+		if prompt := req.Messages[0].Content[0].Text; !strings.Contains(prompt, "Groceries, Household") {
+			t.Errorf("Expected the categories in the prompt, got %q", prompt)
+		}
+		visionReply(w, `{"merchant":"Supermarket","date":"2023-11-15","total":10.80,"tax":0.80,"items":[`+
+			`{"description":"Bread","amount":3.20,"category":"groceries"},`+
+			`{"description":"Soap","amount":7.30,"category":"Household"},`+
+			`{"description":"Soap discount","amount":-0.50,"category":""}]}`)
+	}))
+	defer mockServer.Close()
+
+	cfg := VisionConfig{APIURL: mockServer.URL, Model: "model", Categories: []string{"Groceries", "Household"}}
+	txs, err := ParseReceipt(strings.NewReader("receipt"), "2023-11-15", cfg)
+	if err != nil {
+		t.Fatalf("ParseReceipt failed: %v", err)
+	}
+	if len(txs) != 1 {
+		t.Fatalf("Expected 1 transaction, got %d", len(txs))
+	}
+
+	tx := txs[0]
+	if tx.Description != "Supermarket" || tx.Amount != 10.80 || tx.Type != "withdrawal" || tx.Date != "2023-11-15" {
+		t.Errorf("Unexpected receipt transaction: %+v", tx)
+	}
+	if len(tx.Splits) != 3 {
+		t.Fatalf("Expected 3 splits, got %+v", tx.Splits)
+	}
+	if tx.Splits[0].SuggestedCategory != "Groceries" {
+		t.Errorf("Expected the category to match the Firefly name, got %q", tx.Splits[0].SuggestedCategory)
+	}
+	if tx.Splits[1].Amount != 6.80 {
+		t.Errorf("Expected the discount to be taken off the soap, got %.2f", tx.Splits[1].Amount)
+	}
+	if tx.Splits[2].Description != "Tax" || tx.Splits[2].Amount != 0.80 {
+		t.Errorf("Expected a tax split of 0.80, got %+v", tx.Splits[2])
+	}
+}
+
+func TestReceiptSplits(t *testing.T) {
+	tests := []struct {
+		name    string
+		receipt string
+		want    int
+	}{
+		{"tax included", `{"total":5,"tax":0.4,"items":[{"description":"A","amount":2},{"description":"B","amount":3}]}`, 2},
+		{"unlisted items", `{"total":6,"tax":0,"items":[{"description":"A","amount":2},{"description":"B","amount":3}]}`, 3},
+		{"single item", `{"total":5,"tax":0,"items":[{"description":"A","amount":5}]}`, 0},
+		{"items above total", `{"total":4,"tax":0,"items":[{"description":"A","amount":2},{"description":"B","amount":3}]}`, 0},
+		{"leading discount", `{"total":4,"tax":0,"items":[{"description":"Coupon","amount":-1},{"description":"B","amount":5}]}`, 0},
+	}
+	for _, tt := range tests {
+		var receipt receiptAnswer
+		if err := json.Unmarshal([]byte(tt.receipt), &receipt); err != nil {
+			t.Fatal(err)
+		}
+		if got := receiptSplits(receipt, nil); len(got) != tt.want {
+			t.Errorf("%s: expected %d splits, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"firefly-importer/models"
+)
+
+// HeadSize is the number of leading bytes passed to Parser.Detect.
+const HeadSize = 4096
+
+// VisionConfig holds the settings of the Vision API used to read images.
+type VisionConfig struct {
+	Provider     string // One of the VisionProvider* names; empty uses VisionProviderOpenAI
+	APIURL       string
+	APIKey       string
+	Model        string
+	MaxDimension int         // Longest image side in pixels; 0 uses DefaultVisionMaxDimension
+	MaxBytes     int         // Largest encoded image; 0 uses DefaultVisionMaxBytes
+	Cache        VisionCache // Stores parsed images for re-uploads; nil disables caching
+	Refresh      bool        // Ignore cached results and extract again
+
+	Prompt     models.PromptTemplate // Prompt template; empty or of another kind uses the built-in prompt
+	Account    string                // Name of the account imported into, for the prompt
+	Currency   string                // Currency code of the account, for the prompt
+	Categories []string              // Firefly category names, for the prompt and to match receipt items
+}
+
+// Options carries the upload settings a parser may need. Parsers ignore what they do not use.
+type Options struct {
+	Profile  models.ImportProfile // Column layout for delimited files
+	FileDate string               // Date the file was created, used to resolve relative dates
+	Vision   VisionConfig
+	Receipts bool // Read images as shop receipts, one split transaction each, instead of statement screenshots
+}
+
+// Parser reads one file format into statements.
+type Parser interface {
+	// Name is a short human-readable name of the format, e.g. "OFX".
+	Name() string
+	// Detect scores how likely the file is in this format, from 0 (not this format) to 100
+	// (certain). head holds up to HeadSize leading bytes of the file.
+	Detect(filename string, head []byte) int
+	Parse(r io.Reader, opts Options) ([]Statement, error)
+}
+
+// profileParser is implemented by parsers that read their column layout from Options.Profile.
+type profileParser interface {
+	usesProfile()
+}
+
+// UsesProfile reports whether a parser reads its column layout from Options.Profile.
+func UsesProfile(p Parser) bool {
+	_, ok := p.(profileParser)
+	return ok
+}
+
+// visionParser is implemented by parsers that may send the file to the Vision API.
+type visionParser interface {
+	usesVision()
+}
+
+// UsesVision reports whether a parser may read the file with the Vision API, so that the
+// prompt settings in Options.Vision matter.
+func UsesVision(p Parser) bool {
+	_, ok := p.(visionParser)
+	return ok
+}
+
+// screenshotParser is implemented by parsers of screenshots, where consecutive files usually
+// show some of the same transactions.
+type screenshotParser interface {
+	readsScreenshots()
+}
+
+// ReadsScreenshots reports whether the files of a parser may overlap, so that transactions
+// found in several of them should be kept once.
+func ReadsScreenshots(p Parser) bool {
+	_, ok := p.(screenshotParser)
+	return ok
+}
+
+var registry []Parser
+
+// Register adds a parser to the registry. Formats register themselves from init.
+func Register(p Parser) {
+	registry = append(registry, p)
+}
+
+// Detect returns the registered parser with the highest score for a file. On a tie the parser
+// registered first wins. It returns nil when no parser recognizes the file.
+func Detect(filename string, head []byte) Parser {
+	var best Parser
+	bestScore := 0
+	for _, p := range registry {
+		if score := p.Detect(filename, head); score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// ReadHead reads up to HeadSize bytes for detection and returns a reader that still yields
+// the whole input.
+func ReadHead(r io.Reader) ([]byte, io.Reader, error) {
+	head := make([]byte, HeadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	head = head[:n]
+	return head, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// hasExtension reports whether the filename ends in one of the given lowercase extensions.
+func hasExtension(filename string, exts ...string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range exts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// textHead strips a UTF-8 byte order mark and leading whitespace from head.
+func textHead(head []byte) string {
+	return strings.TrimSpace(strings.TrimPrefix(string(head), "\uFEFF"))
+}
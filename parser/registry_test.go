@@ -0,0 +1,59 @@
+package parser
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		filename string
+		content  string
+		want     string
+	}{
+		{"export.txt", "Date,Description,Amount\n2023-10-01,Groceries,-45.50\n", "CSV"},
+		{"export.txt", "Kontonummer: 123456789\nDatum;Text;Betrag\n01.10.2023;REWE;-1,50\n", "CSV"},
+		{"export.csv", "Date;Description;Amount\n", "CSV"},
+		{"download.txt", "OFXHEADER:100\nDATA:OFXSGML\n\n<OFX>", "OFX"},
+		{"statement.csv", "<?xml version=\"1.0\"?>\n<Document><BkToCstmrStmt>", "camt.052/053"},
+		{"statement.txt", ":20:STATEMENT-001\n:25:NL91ABNA0417164300\n", "MT940"},
+		{"export.dat", "\uFEFF!Type:Bank\nD10/01/2023\n", "QIF"},
+		{"screenshot.jpg", "RIFF\x10\x00\x00\x00WEBPVP8 ", "Image"},
+		{"IMG_1234", "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00", "Image"},
+		{"photo", "\x89PNG\r\n\x1a\n\x00\x00", "Image"},
+		{"download", "PK\x03\x04\x14\x00\x00\x00xl/workbook.xml", "Excel"},
+		{"old.xls", "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", "Excel 97-2003"},
+		{"statement", "%PDF-1.7\n%\xe2\xe3\xcf\xd3", "PDF"},
+	}
+
+	for _, tt := range tests {
+		p := Detect(tt.filename, []byte(tt.content))
+		if p == nil {
+			t.Errorf("Detect(%q) found no parser, want %s", tt.filename, tt.want)
+			continue
+		}
+		if p.Name() != tt.want {
+			t.Errorf("Detect(%q) = %s, want %s", tt.filename, p.Name(), tt.want)
+		}
+	}
+
+	if p := Detect("notes.txt", []byte("just some notes")); p != nil {
+		t.Errorf("Expected no parser for plain text, got %s", p.Name())
+	}
+}
+
+func TestReadHead(t *testing.T) {
+	content := strings.Repeat("a", HeadSize+10)
+	head, r, err := ReadHead(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ReadHead failed: %v", err)
+	}
+	if len(head) != HeadSize {
+		t.Errorf("Expected head of %d bytes, got %d", HeadSize, len(head))
+	}
+	all, _ := io.ReadAll(r)
+	if string(all) != content {
+		t.Errorf("Expected the reader to yield the whole input, got %d bytes", len(all))
+	}
+}
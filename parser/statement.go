@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+
+	"firefly-importer/models"
+)
+
+// Balance is a booked account balance as reported by a bank statement.
+type Balance struct {
+	Date     string  // Format: YYYY-MM-DD
+	Amount   float64 // Signed; negative for a debit balance
+	Currency string
+}
+
+// Statement is a batch of parsed transactions that belong to a single bank account.
+// Formats without account information produce a single Statement with an empty IBAN,
+// which is imported into the account picked in the upload form.
+type Statement struct {
+	IBAN           string
+	Currency       string
+	OpeningBalance *Balance
+	ClosingBalance *Balance
+	Transactions   []models.Transaction
+	Diagnostics    []Diagnostic
+}
+
+// Diagnostic explains why a row of the input could not be turned into a transaction.
+// Transaction holds whatever could be read from the row so that it can be fixed by hand.
+type Diagnostic struct {
+	Line        int    // 1-based line in the file; 0 when the format has no meaningful lines
+	Raw         string // The row as it appears in the file
+	Reason      string
+	Transaction models.Transaction
+}
+
+// SingleStatement wraps transactions and diagnostics from a format without account information.
+func SingleStatement(transactions []models.Transaction, diagnostics []Diagnostic) []Statement {
+	return []Statement{{Transactions: transactions, Diagnostics: diagnostics}}
+}
+
+// Reconcile checks that the opening balance plus the parsed transactions adds up to the
+// closing balance. Statements without both balances are not checked.
+func (s Statement) Reconcile() error {
+	if s.OpeningBalance == nil || s.ClosingBalance == nil {
+		return nil
+	}
+
+	expected := s.OpeningBalance.Amount
+	for _, tx := range s.Transactions {
+		if tx.Type == "withdrawal" {
+			expected -= tx.Amount
+		} else {
+			expected += tx.Amount
+		}
+	}
+
+	if math.Abs(expected-s.ClosingBalance.Amount) >= 0.005 {
+		return fmt.Errorf("opening balance %.2f plus transactions gives %.2f, but the statement closes at %.2f on %s",
+			s.OpeningBalance.Amount, expected, s.ClosingBalance.Amount, s.ClosingBalance.Date)
+	}
+	return nil
+}
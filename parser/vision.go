@@ -0,0 +1,447 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Vision provider names, as set in VisionConfig.Provider.
+const (
+	VisionProviderOpenAI    = "openai"           // OpenAI-compatible /v1/chat/completions
+	VisionProviderOllama    = "ollama"           // Ollama's native /api/chat
+	VisionProviderResponses = "openai-responses" // OpenAI Responses API, /v1/responses
+)
+
+// VisionImage is an encoded image sent to a vision model.
+type VisionImage struct {
+	MIMEType string
+	Data     []byte
+}
+
+// DataURL encodes the image as a base64 data URL.
+func (img VisionImage) DataURL() string {
+	return fmt.Sprintf("data:%s;base64,%s", img.MIMEType, base64.StdEncoding.EncodeToString(img.Data))
+}
+
+// VisionMessage is one turn of a conversation with a vision model.
+type VisionMessage struct {
+	Role   string // "user" or "assistant"
+	Text   string
+	Images []VisionImage
+}
+
+// JSONSchema describes the structure the model's answer must follow.
+type JSONSchema struct {
+	Name   string
+	Schema map[string]any
+}
+
+// VisionRequest is a provider-neutral request to a vision model.
+type VisionRequest struct {
+	Model    string
+	Messages []VisionMessage
+	Schema   *JSONSchema // Requests structured output when the endpoint supports it; nil for free text
+}
+
+// VisionProvider sends requests to a vision model API and returns the model's answer text.
+type VisionProvider interface {
+	Complete(req VisionRequest) (string, error)
+}
+
+// NewVisionProvider returns the provider with the given name for an API base URL. An empty
+// name selects the OpenAI-compatible chat API.
+func NewVisionProvider(name, apiURL, apiKey string) (VisionProvider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", VisionProviderOpenAI:
+		return openAIChatProvider{endpoint: versionedEndpoint(apiURL, "/chat/completions"), apiKey: apiKey}, nil
+	case VisionProviderOllama:
+		base := strings.TrimRight(apiURL, "/")
+		if !strings.HasSuffix(base, "/api") {
+			base += "/api"
+		}
+		return ollamaProvider{endpoint: base + "/chat", apiKey: apiKey}, nil
+	case VisionProviderResponses:
+		return responsesProvider{endpoint: versionedEndpoint(apiURL, "/responses"), apiKey: apiKey}, nil
+	}
+	return nil, fmt.Errorf("unknown vision provider %q", name)
+}
+
+var apiVersionSuffix = regexp.MustCompile(`/v\d+$`)
+
+// versionedEndpoint appends an OpenAI API path to a base URL, adding /v1 unless the base URL
+// already ends in a version such as https://api.openai.com/v1.
+func versionedEndpoint(apiURL, path string) string {
+	base := strings.TrimRight(apiURL, "/")
+	if !apiVersionSuffix.MatchString(base) {
+		base += "/v1"
+	}
+	return base + path
+}
+
+// transactionsSchema describes the vision answer: an object wrapping the transaction array,
+// since strict structured output requires an object at the top level.
+var transactionsSchema = JSONSchema{
+	Name: "transactions",
+	Schema: map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"transactions": map[string]any{
+				"type": "array",
+				"items": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"date":        map[string]any{"type": "string", "description": "YYYY-MM-DD"},
+						"description": map[string]any{"type": "string"},
+						"amount":      map[string]any{"type": "number", "description": "Absolute value"},
+						"type":        map[string]any{"type": "string", "enum": []string{"withdrawal", "deposit"}},
+						"confidence": map[string]any{
+							"type": "object",
+							"properties": map[string]any{
+								"date":        map[string]any{"type": "number"},
+								"description": map[string]any{"type": "number"},
+								"amount":      map[string]any{"type": "number"},
+								"type":        map[string]any{"type": "number"},
+							},
+							"required":             []string{"date", "description", "amount", "type"},
+							"additionalProperties": false,
+						},
+						"box": map[string]any{
+							"type":        []string{"object", "null"},
+							"description": "Fractions of the image size from the top left",
+							"properties": map[string]any{
+								"x":      map[string]any{"type": "number"},
+								"y":      map[string]any{"type": "number"},
+								"width":  map[string]any{"type": "number"},
+								"height": map[string]any{"type": "number"},
+							},
+							"required":             []string{"x", "y", "width", "height"},
+							"additionalProperties": false,
+						},
+					},
+					"required":             []string{"date", "description", "amount", "type", "confidence", "box"},
+					"additionalProperties": false,
+				},
+			},
+		},
+		"required":             []string{"transactions"},
+		"additionalProperties": false,
+	},
+}
+
+// structuredOutputRetryAfter is how long an endpoint that rejected a JSON schema is sent plain
+// prompts, before structured output is tried again, e.g. after the server was upgraded.
+const structuredOutputRetryAfter = time.Hour
+
+// structuredOutputUnsupported remembers when endpoints rejected a JSON schema, so requests in
+// the following structuredOutputRetryAfter skip straight to plain prompting.
+var structuredOutputUnsupported sync.Map // endpoint -> time.Time
+
+// useSchema returns the schema to send to an endpoint, or nil when it recently rejected it.
+func useSchema(endpoint string, schema *JSONSchema) *JSONSchema {
+	if rejected, ok := structuredOutputUnsupported.Load(endpoint); ok {
+		if time.Since(rejected.(time.Time)) < structuredOutputRetryAfter {
+			return nil
+		}
+		structuredOutputUnsupported.Delete(endpoint)
+	}
+	return schema
+}
+
+// visionAPIError is a non-200 response from a vision API.
+type visionAPIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *visionAPIError) Error() string {
+	return fmt.Sprintf("vision API returned non-200 status %d: %s", e.StatusCode, e.Body)
+}
+
+// schemaErrorMarkers are the structured output parameters named in errors of endpoints that
+// reject them. A bare "format" is not enough, since it also appears in errors such as
+// "invalid image format".
+var schemaErrorMarkers = []string{"response_format", "json_schema", "json schema", "text.format", "structured output"}
+
+// rejectsSchema reports whether an error complains about the structured output settings.
+func rejectsSchema(err error) bool {
+	var apiErr *visionAPIError
+	if !errors.As(err, &apiErr) || (apiErr.StatusCode != http.StatusBadRequest && apiErr.StatusCode != http.StatusUnprocessableEntity) {
+		return false
+	}
+	body := strings.ToLower(apiErr.Body)
+	for _, marker := range schemaErrorMarkers {
+		if strings.Contains(body, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// completeWithFallback sends a request built with the schema, and again without it when the
+// endpoint rejects structured output, remembering the endpoint for later requests.
+func completeWithFallback(endpoint string, schema *JSONSchema, send func(schema *JSONSchema) (string, error)) (string, error) {
+	schema = useSchema(endpoint, schema)
+	content, err := send(schema)
+	if err != nil && schema != nil && rejectsSchema(err) {
+		structuredOutputUnsupported.Store(endpoint, time.Now())
+		return send(nil)
+	}
+	return content, err
+}
+
+// visionHTTPClient sends vision requests. Local models can take minutes to read a large
+// screenshot, but a stalled endpoint must not hang the upload forever.
+var visionHTTPClient = &http.Client{Timeout: 5 * time.Minute}
+
+// postJSON sends a JSON payload and decodes the JSON response into out.
+func postJSON(endpoint, apiKey string, payload, out any) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode vision payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create vision request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := visionHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("vision API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &visionAPIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode vision response: %w", err)
+	}
+	return nil
+}
+
+// openAIChatProvider speaks the OpenAI-compatible chat completions API, which most local
+// servers such as llama.cpp, vLLM and LM Studio implement.
+type openAIChatProvider struct {
+	endpoint string
+	apiKey   string
+}
+
+type chatRequest struct {
+	Model          string          `json:"model"`
+	Messages       []chatMessage   `json:"messages"`
+	ResponseFormat *responseFormat `json:"response_format,omitempty"`
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content []any  `json:"content"`
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type imageContent struct {
+	Type     string            `json:"type"`
+	ImageURL map[string]string `json:"image_url"`
+}
+
+// responseFormat asks for structured output matching a JSON schema.
+type responseFormat struct {
+	Type       string     `json:"type"`
+	JSONSchema jsonSchema `json:"json_schema"`
+}
+
+type jsonSchema struct {
+	Name   string         `json:"name"`
+	Strict bool           `json:"strict"`
+	Schema map[string]any `json:"schema"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (p openAIChatProvider) Complete(req VisionRequest) (string, error) {
+	return completeWithFallback(p.endpoint, req.Schema, func(schema *JSONSchema) (string, error) {
+		payload := chatRequest{Model: req.Model}
+		for _, m := range req.Messages {
+			content := []any{textContent{Type: "text", Text: m.Text}}
+			for _, img := range m.Images {
+				content = append(content, imageContent{Type: "image_url", ImageURL: map[string]string{"url": img.DataURL()}})
+			}
+			payload.Messages = append(payload.Messages, chatMessage{Role: m.Role, Content: content})
+		}
+		if schema != nil {
+			payload.ResponseFormat = &responseFormat{
+				Type:       "json_schema",
+				JSONSchema: jsonSchema{Name: schema.Name, Strict: true, Schema: schema.Schema},
+			}
+		}
+
+		var resp chatResponse
+		if err := postJSON(p.endpoint, p.apiKey, payload, &resp); err != nil {
+			return "", err
+		}
+		if len(resp.Choices) == 0 {
+			return "", errors.New("no content parsed by vision API")
+		}
+		return resp.Choices[0].Message.Content, nil
+	})
+}
+
+// ollamaProvider speaks Ollama's native chat API, which takes images as plain base64 and a
+// JSON schema as the format.
+type ollamaProvider struct {
+	endpoint string
+	apiKey   string
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   map[string]any  `json:"format,omitempty"`
+}
+
+type ollamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+func (p ollamaProvider) Complete(req VisionRequest) (string, error) {
+	return completeWithFallback(p.endpoint, req.Schema, func(schema *JSONSchema) (string, error) {
+		payload := ollamaRequest{Model: req.Model}
+		for _, m := range req.Messages {
+			msg := ollamaMessage{Role: m.Role, Content: m.Text}
+			for _, img := range m.Images {
+				msg.Images = append(msg.Images, base64.StdEncoding.EncodeToString(img.Data))
+			}
+			payload.Messages = append(payload.Messages, msg)
+		}
+		if schema != nil {
+			payload.Format = schema.Schema
+		}
+
+		var resp ollamaResponse
+		if err := postJSON(p.endpoint, p.apiKey, payload, &resp); err != nil {
+			return "", err
+		}
+		return resp.Message.Content, nil
+	})
+}
+
+// responsesProvider speaks the OpenAI Responses API.
+type responsesProvider struct {
+	endpoint string
+	apiKey   string
+}
+
+type responsesRequest struct {
+	Model string           `json:"model"`
+	Input []responsesInput `json:"input"`
+	Text  *responsesText   `json:"text,omitempty"`
+}
+
+type responsesInput struct {
+	Role    string          `json:"role"`
+	Content []responsesPart `json:"content"`
+}
+
+type responsesPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+}
+
+type responsesText struct {
+	Format responsesFormat `json:"format"`
+}
+
+type responsesFormat struct {
+	Type   string         `json:"type"`
+	Name   string         `json:"name"`
+	Strict bool           `json:"strict"`
+	Schema map[string]any `json:"schema"`
+}
+
+type responsesResponse struct {
+	Output []struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"output"`
+}
+
+func (p responsesProvider) Complete(req VisionRequest) (string, error) {
+	return completeWithFallback(p.endpoint, req.Schema, func(schema *JSONSchema) (string, error) {
+		payload := responsesRequest{Model: req.Model}
+		for _, m := range req.Messages {
+			// Earlier answers of the model are output text, everything else is input
+			textType := "input_text"
+			if m.Role == "assistant" {
+				textType = "output_text"
+			}
+			content := []responsesPart{{Type: textType, Text: m.Text}}
+			for _, img := range m.Images {
+				content = append(content, responsesPart{Type: "input_image", ImageURL: img.DataURL()})
+			}
+			payload.Input = append(payload.Input, responsesInput{Role: m.Role, Content: content})
+		}
+		if schema != nil {
+			payload.Text = &responsesText{Format: responsesFormat{Type: "json_schema", Name: schema.Name, Strict: true, Schema: schema.Schema}}
+		}
+
+		var resp responsesResponse
+		if err := postJSON(p.endpoint, p.apiKey, payload, &resp); err != nil {
+			return "", err
+		}
+
+		var b strings.Builder
+		for _, item := range resp.Output {
+			if item.Type != "message" {
+				continue
+			}
+			for _, c := range item.Content {
+				if c.Type == "output_text" {
+					b.WriteString(c.Text)
+				}
+			}
+		}
+		if b.Len() == 0 {
+			return "", errors.New("no content parsed by vision API")
+		}
+		return b.String(), nil
+	})
+}
@@ -0,0 +1,202 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const visionTestAnswer = `{"transactions":[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"withdrawal"}]}`
+
+func TestNewVisionProviderEndpoints(t *testing.T) {
+	tests := []struct {
+		provider, url, want string
+	}{
+		{"", "https://ai.example.com/api", "https://ai.example.com/api/v1/chat/completions"},
+		{"openai", "https://api.openai.com/v1", "https://api.openai.com/v1/chat/completions"},
+		{"openai", "https://api.openai.com/v1/", "https://api.openai.com/v1/chat/completions"},
+		{"openai", "http://localhost:8000", "http://localhost:8000/v1/chat/completions"},
+		{"ollama", "http://localhost:11434", "http://localhost:11434/api/chat"},
+		{"ollama", "http://localhost:11434/api/", "http://localhost:11434/api/chat"},
+		{"openai-responses", "https://api.openai.com/v1", "https://api.openai.com/v1/responses"},
+		{"openai-responses", "https://api.openai.com", "https://api.openai.com/v1/responses"},
+	}
+	for _, tt := range tests {
+		provider, err := NewVisionProvider(tt.provider, tt.url, "")
+		if err != nil {
+			t.Fatalf("NewVisionProvider(%q) failed: %v", tt.provider, err)
+		}
+		var got string
+		switch p := provider.(type) {
+		case openAIChatProvider:
+			got = p.endpoint
+		case ollamaProvider:
+			got = p.endpoint
+		case responsesProvider:
+			got = p.endpoint
+		}
+		if got != tt.want {
+			t.Errorf("NewVisionProvider(%q, %q) endpoint = %q, want %q", tt.provider, tt.url, got, tt.want)
+		}
+	}
+
+	if _, err := NewVisionProvider("gemini", "http://localhost", ""); err == nil {
+		t.Error("Expected an error for an unknown provider")
+	}
+}
+
+func TestParseImageOpenAIVersionedURL(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("Expected /v1/chat/completions, got %s", r.URL.Path)
+		}
+		visionReply(w, visionTestAnswer)
+	}))
+	defer mockServer.Close()
+
+	txs, err := ParseImage(strings.NewReader("image"), "2023-11-15", mockServer.URL+"/v1", "", "model")
+	if err != nil {
+		t.Fatalf("ParseImage failed: %v", err)
+	}
+	if len(txs) != 1 {
+		t.Errorf("Expected 1 transaction, got %d", len(txs))
+	}
+}
+
+func TestParseImageOllama(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("Expected /api/chat, got %s", r.URL.Path)
+		}
+		var req struct {
+			Model    string `json:"model"`
+			Stream   bool   `json:"stream"`
+			Format   any    `json:"format"`
+			Messages []struct {
+				Content string   `json:"content"`
+				Images  []string `json:"images"`
+			} `json:"messages"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "llava" || req.Stream {
+			t.Errorf("Expected a non-streaming llava request, got model %q stream %v", req.Model, req.Stream)
+		}
+		if req.Format == nil {
+			t.Error("Expected the JSON schema as format")
+		}
+		// aW1hZ2U= is "image" in base64, without a data URL prefix
+		if len(req.Messages) != 1 || len(req.Messages[0].Images) != 1 || req.Messages[0].Images[0] != "aW1hZ2U=" {
+			t.Errorf("Expected one message with the plain base64 image, got %+v", req.Messages)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"message": map[string]string{"role": "assistant", "content": visionTestAnswer}})
+	}))
+	defer mockServer.Close()
+
+	cfg := VisionConfig{Provider: VisionProviderOllama, APIURL: mockServer.URL, Model: "llava"}
+	txs, err := ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+	if err != nil {
+		t.Fatalf("ParseImageWithConfig failed: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Description != "Coffee Shop" {
+		t.Errorf("Expected the Ollama transaction, got %+v", txs)
+	}
+}
+
+func TestParseImageResponses(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/v1/responses" {
+			t.Errorf("Expected /v1/responses, got %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			t.Errorf("Expected Bearer test-key, got %s", r.Header.Get("Authorization"))
+		}
+		var req struct {
+			Input []struct {
+				Role    string `json:"role"`
+				Content []struct {
+					Type     string `json:"type"`
+					ImageURL string `json:"image_url"`
+				} `json:"content"`
+			} `json:"input"`
+			Text struct {
+				Format struct {
+					Type string `json:"type"`
+				} `json:"format"`
+			} `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Text.Format.Type != "json_schema" {
+			t.Errorf("Expected a json_schema text format, got %q", req.Text.Format.Type)
+		}
+		content := req.Input[0].Content
+		if len(content) != 2 || content[0].Type != "input_text" || content[1].Type != "input_image" ||
+			!strings.HasPrefix(content[1].ImageURL, "data:") {
+			t.Errorf("Expected input text and a data URL image, got %+v", content)
+		}
+
+		answer := visionTestAnswer
+		if len(req.Input) == 1 {
+			// First answer is unusable, so the retry carries it back as output text
+			answer = `[{"date":"2023-11-15","description":"Coffee Shop","amount":4.5,"type":"debit"}]`
+		} else if req.Input[1].Role != "assistant" || req.Input[1].Content[0].Type != "output_text" {
+			t.Errorf("Expected the earlier answer as assistant output text, got %+v", req.Input[1])
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"output":[{"type":"reasoning","content":[]},{"type":"message","role":"assistant","content":[{"type":"output_text","text":%q}]}]}`, answer)
+	}))
+	defer mockServer.Close()
+
+	cfg := VisionConfig{Provider: VisionProviderResponses, APIURL: mockServer.URL + "/v1", APIKey: "test-key", Model: "model"}
+	txs, err := ParseImageWithConfig(strings.NewReader("image"), "2023-11-15", cfg)
+	if err != nil {
+		t.Fatalf("ParseImageWithConfig failed: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Type != "withdrawal" {
+		t.Errorf("Expected the corrected transaction, got %+v", txs)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+}
+
+func TestRejectsSchema(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&visionAPIError{StatusCode: 400, Body: `{"error":"response_format is not supported"}`}, true},
+		{&visionAPIError{StatusCode: 400, Body: `{"error":{"param":"text.format"}}`}, true},
+		{&visionAPIError{StatusCode: 400, Body: `{"error":"invalid JSON schema in format"}`}, true},
+		{&visionAPIError{StatusCode: 400, Body: `{"error":"invalid image format"}`}, false},
+		{&visionAPIError{StatusCode: 500, Body: `{"error":"response_format failed"}`}, false},
+		{fmt.Errorf("request failed"), false},
+	}
+	for _, tt := range tests {
+		if got := rejectsSchema(tt.err); got != tt.want {
+			t.Errorf("rejectsSchema(%v): expected %v, got %v", tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestUseSchemaExpires(t *testing.T) {
+	endpoint := "http://vision.test/expiry"
+	defer structuredOutputUnsupported.Delete(endpoint)
+
+	structuredOutputUnsupported.Store(endpoint, time.Now())
+	if useSchema(endpoint, &transactionsSchema) != nil {
+		t.Error("Expected no schema for an endpoint that just rejected it")
+	}
+	structuredOutputUnsupported.Store(endpoint, time.Now().Add(-structuredOutputRetryAfter))
+	if useSchema(endpoint, &transactionsSchema) == nil {
+		t.Error("Expected the schema to be tried again after the retry period")
+	}
+}
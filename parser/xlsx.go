@@ -0,0 +1,380 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"firefly-importer/models"
+)
+
+type xlsxWorkbook struct {
+	Properties struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is rich or plain text: either a single <t> or runs of <r><t>.
+type xlsxText struct {
+	Text string   `xml:"t"`
+	Runs []string `xml:"r>t"`
+}
+
+func (t xlsxText) String() string {
+	if len(t.Runs) > 0 {
+		return strings.Join(t.Runs, "")
+	}
+	return t.Text
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxStyles struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxSheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Style  int      `xml:"s,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// xlsxWorkbookData holds the workbook parts needed to render cell values.
+type xlsxWorkbookData struct {
+	sharedStrings []string
+	dateStyles    map[int]bool // Indexes into cellXfs whose number format is a date
+	date1904      bool
+}
+
+// ParseXLSX reads the sheet selected by the profile (the first one by default) from an Excel
+// workbook and maps its rows like ParseCSV does. Date cells are rendered as YYYY-MM-DD and
+// numeric cells use the profile's decimal separator.
+func ParseXLSX(r io.Reader, profile models.ImportProfile) ([]models.Transaction, []Diagnostic, error) {
+	if err := ValidateProfile(profile); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read xlsx file: %w", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open xlsx file: %w", err)
+	}
+
+	rows, err := readXLSXRows(zr, profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mapRows(rows, profile, ',')
+}
+
+func readXLSXRows(zr *zip.Reader, profile models.ImportProfile) ([]sourceRow, error) {
+	var wb xlsxWorkbook
+	if err := decodeZipXML(zr, "xl/workbook.xml", &wb); err != nil {
+		return nil, err
+	}
+	if len(wb.Sheets) == 0 {
+		return nil, errors.New("workbook has no sheets")
+	}
+
+	sheetPath, err := xlsxSheetPath(zr, wb, profile.Sheet)
+	if err != nil {
+		return nil, err
+	}
+
+	book := xlsxWorkbookData{date1904: wb.Properties.Date1904}
+
+	var shared xlsxSharedStrings
+	if err := decodeZipXML(zr, "xl/sharedStrings.xml", &shared); err != nil && !errors.Is(err, errZipEntryNotFound) {
+		return nil, err
+	}
+	for _, si := range shared.Items {
+		book.sharedStrings = append(book.sharedStrings, si.String())
+	}
+
+	var styles xlsxStyles
+	if err := decodeZipXML(zr, "xl/styles.xml", &styles); err != nil && !errors.Is(err, errZipEntryNotFound) {
+		return nil, err
+	}
+	book.dateStyles = xlsxDateStyles(styles)
+
+	var sheet xlsxSheet
+	if err := decodeZipXML(zr, sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	var rows []sourceRow
+	for i, row := range sheet.Rows {
+		number := row.Number
+		if number == 0 {
+			number = i + 1
+		}
+
+		var record []string
+		for j, c := range row.Cells {
+			col := j
+			if c.Ref != "" {
+				col = xlsxColumn(c.Ref)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			record[col] = book.cellValue(c.Type, c.Style, c.Value, c.Inline, profile.DecimalSeparator)
+		}
+
+		if isBlankRecord(record) {
+			continue
+		}
+		rows = append(rows, sourceRow{line: number, record: record})
+	}
+	return rows, nil
+}
+
+// xlsxSheetPath resolves the zip path of the selected sheet. sheet is a name or a 1-based index.
+func xlsxSheetPath(zr *zip.Reader, wb xlsxWorkbook, sheet string) (string, error) {
+	idx := 0
+	if sheet = strings.TrimSpace(sheet); sheet != "" {
+		idx = -1
+		for i, s := range wb.Sheets {
+			if strings.EqualFold(s.Name, sheet) {
+				idx = i
+				break
+			}
+		}
+		if n, err := strconv.Atoi(sheet); idx < 0 && err == nil && n >= 1 && n <= len(wb.Sheets) {
+			idx = n - 1
+		}
+		if idx < 0 {
+			return "", fmt.Errorf("sheet %q not found in workbook", sheet)
+		}
+	}
+
+	var rels xlsxRelationships
+	if err := decodeZipXML(zr, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID == wb.Sheets[idx].RID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "", fmt.Errorf("sheet %q has no worksheet part", wb.Sheets[idx].Name)
+}
+
+var errZipEntryNotFound = errors.New("zip entry not found")
+
+func decodeZipXML(zr *zip.Reader, name string, v any) error {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(rc).Decode(v); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", name, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s: %w", name, errZipEntryNotFound)
+}
+
+// xlsxColumn converts the letters of a cell reference such as "AB12" to a zero-based column.
+func xlsxColumn(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
+
+// xlsxDateStyles finds the cell styles whose number format displays a date.
+func xlsxDateStyles(styles xlsxStyles) map[int]bool {
+	custom := make(map[int]string)
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = f.Code
+	}
+
+	dateStyles := make(map[int]bool)
+	for i, xf := range styles.CellXfs {
+		if code, ok := custom[xf.NumFmtID]; ok {
+			dateStyles[i] = isDateFormatCode(code)
+			continue
+		}
+		// Built-in date formats; 18-21 and 45-47 are time-only
+		id := xf.NumFmtID
+		dateStyles[i] = (id >= 14 && id <= 17) || id == 22 || (id >= 27 && id <= 36) || (id >= 50 && id <= 58)
+	}
+	return dateStyles
+}
+
+// isDateFormatCode reports whether a custom number format displays a date, ignoring quoted
+// literals, escaped characters and bracketed sections such as colours and locales. An "m"
+// next to hours or seconds means minutes, so time-only formats are not dates.
+func isDateFormatCode(code string) bool {
+	inQuote, inBracket, escaped := false, false, false
+	hasMonth, hasTime := false, false
+	for _, r := range strings.ToLower(code) {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case r == '[':
+			inBracket = true
+		case r == ']':
+			inBracket = false
+		case inBracket:
+		case r == 'd' || r == 'y':
+			return true
+		case r == 'm':
+			hasMonth = true
+		case r == 'h' || r == 's':
+			hasTime = true
+		}
+	}
+	return hasMonth && !hasTime
+}
+
+func (b xlsxWorkbookData) cellValue(cellType string, style int, value string, inline xlsxText, decimalSep string) string {
+	switch cellType {
+	case "s":
+		idx, err := strconv.Atoi(value)
+		if err != nil || idx < 0 || idx >= len(b.sharedStrings) {
+			return ""
+		}
+		return b.sharedStrings[idx]
+	case "inlineStr":
+		return inline.String()
+	case "str", "e":
+		return value
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+
+	// Numeric cell
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return value
+	}
+	if b.dateStyles[style] {
+		return excelSerialDate(n, b.date1904)
+	}
+
+	formatted := strconv.FormatFloat(n, 'f', -1, 64)
+	switch {
+	case decimalSep == ",":
+		formatted = strings.Replace(formatted, ".", ",", 1)
+	case decimalSep == "" && len(formatted)-strings.Index(formatted, ".") == 4 && strings.Contains(formatted, "."):
+		// Amount detection reads a dot followed by three digits as a thousands separator
+		formatted += "0"
+	}
+	return formatted
+}
+
+// excelSerialDate converts an Excel date serial to YYYY-MM-DD. The 1900 date system counts
+// from 1899-12-30 to account for Excel treating 1900 as a leap year.
+func excelSerialDate(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return epoch.AddDate(0, 0, int(math.Floor(serial))).Format("2006-01-02")
+}
+
+func init() {
+	Register(xlsxParser{})
+	Register(xlsParser{})
+}
+
+type xlsxParser struct{}
+
+func (xlsxParser) Name() string { return "Excel" }
+
+func (xlsxParser) usesProfile() {}
+
+func (xlsxParser) Detect(filename string, head []byte) int {
+	isZip := bytes.HasPrefix(head, []byte("PK\x03\x04"))
+	switch {
+	case isZip && (hasExtension(filename, ".xlsx", ".xlsm") || bytes.Contains(head, []byte("xl/"))):
+		return 90
+	case hasExtension(filename, ".xlsx", ".xlsm"):
+		return 50
+	}
+	return 0
+}
+
+func (xlsxParser) Parse(r io.Reader, opts Options) ([]Statement, error) {
+	txs, diags, err := ParseXLSX(r, opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	return SingleStatement(txs, diags), nil
+}
+
+// xlsParser recognizes legacy binary Excel workbooks only to explain that they must be
+// converted; the BIFF format is not supported.
+type xlsParser struct{}
+
+func (xlsParser) Name() string { return "Excel 97-2003" }
+
+func (xlsParser) Detect(filename string, head []byte) int {
+	if bytes.HasPrefix(head, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")) || hasExtension(filename, ".xls") {
+		return 50
+	}
+	return 0
+}
+
+func (xlsParser) Parse(io.Reader, Options) ([]Statement, error) {
+	return nil, errors.New("legacy .xls workbooks are not supported; open the file in a spreadsheet program and save it as .xlsx or .csv")
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"firefly-importer/models"
+)
+
+// buildXLSX zips the given parts into a minimal workbook with two sheets.
+func buildXLSX(t *testing.T, sheet1, sheet2 string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"[Content_Types].xml": `<?xml version="1.0"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"/>`,
+		"xl/workbook.xml": `<?xml version="1.0"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets><sheet name="Summary" sheetId="1" r:id="rId1"/><sheet name="Transactions" sheetId="2" r:id="rId2"/></sheets>
+</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Target="worksheets/sheet1.xml"/>
+  <Relationship Id="rId2" Target="worksheets/sheet2.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>Date</t></si><si><t>Description</t></si><si><t>Amount</t></si>
+  <si><r><t>Bäckerei </t></r><r><t>Müller</t></r></si>
+  <si><t>Account NL91ABNA0417164300</t></si>
+</sst>`,
+		"xl/styles.xml": `<?xml version="1.0"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <numFmts><numFmt numFmtId="164" formatCode="dd\.mm\.yyyy"/><numFmt numFmtId="165" formatCode="#,##0.00 [$EUR]"/></numFmts>
+  <cellXfs><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="165"/><xf numFmtId="14"/></cellXfs>
+</styleSheet>`,
+		"xl/worksheets/sheet1.xml": sheet1,
+		"xl/worksheets/sheet2.xml": sheet2,
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseXLSX(t *testing.T) {
+	sheet := `<?xml version="1.0"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="s"><v>4</v></c></row>
+  <row r="3"><c r="A3" t="s"><v>0</v></c><c r="B3" t="s"><v>1</v></c><c r="C3" t="s"><v>2</v></c></row>
+  <row r="4"><c r="A4" s="1"><v>45200</v></c><c r="B4" t="s"><v>3</v></c><c r="C4" s="2"><v>-1234.5</v></c></row>
+  <row r="5"><c r="A5" s="3"><v>45201.75</v></c><c r="B5" t="inlineStr"><is><t>Salary</t></is></c><c r="C5"><v>2500.125</v></c></row>
+  <row r="6"><c r="A6" t="str"><v>not a date</v></c><c r="B6" t="inlineStr"><is><t>Broken</t></is></c><c r="C6"><v>1</v></c></row>
+</sheetData></worksheet>`
+	data := buildXLSX(t, `<worksheet><sheetData/></worksheet>`, sheet)
+
+	profile := models.ImportProfile{
+		HasHeader:         true,
+		DateColumn:        "Date",
+		DescriptionColumn: "Description",
+		AmountColumn:      "Amount",
+		AmountMode:        models.AmountModeSigned,
+		Sheet:             "transactions",
+	}
+
+	txs, diags, err := ParseXLSX(bytes.NewReader(data), profile)
+	if err != nil {
+		t.Fatalf("ParseXLSX failed: %v", err)
+	}
+	if len(txs) != 2 {
+		t.Fatalf("Expected 2 transactions, got %d", len(txs))
+	}
+
+	if txs[0].Date != "2023-10-01" || txs[0].Description != "Bäckerei Müller" {
+		t.Errorf("Unexpected first transaction: %+v", txs[0])
+	}
+	if txs[0].Amount != 1234.50 || txs[0].Type != "withdrawal" {
+		t.Errorf("Expected withdrawal of 1234.50, got %s of %f", txs[0].Type, txs[0].Amount)
+	}
+	if txs[1].Date != "2023-10-02" || txs[1].Amount != 2500.125 {
+		t.Errorf("Unexpected second transaction: %+v", txs[1])
+	}
+
+	if len(diags) != 1 || diags[0].Line != 6 {
+		t.Errorf("Expected a diagnostic for row 6, got %+v", diags)
+	}
+
+	// A comma decimal separator applies to numeric cells as well
+	profile.DecimalSeparator = ","
+	txs, _, err = ParseXLSX(bytes.NewReader(data), profile)
+	if err != nil {
+		t.Fatalf("ParseXLSX failed: %v", err)
+	}
+	if len(txs) != 2 || txs[0].Amount != 1234.50 {
+		t.Errorf("Expected amount 1234.50 with a comma separator, got %+v", txs)
+	}
+
+	profile.Sheet = "Missing"
+	if _, _, err := ParseXLSX(bytes.NewReader(data), profile); err == nil || !strings.Contains(err.Error(), "Missing") {
+		t.Errorf("Expected an error for a missing sheet, got %v", err)
+	}
+}
+
+func TestIsDateFormatCode(t *testing.T) {
+	tests := map[string]bool{
+		`dd\.mm\.yyyy`:      true,
+		`m/d/yy h:mm`:       true,
+		`mmm yyyy`:          true,
+		`h:mm:ss`:           false,
+		`#,##0.00 [$EUR]`:   false,
+		`"Day "0`:           false,
+		`[Red]#,##0.00;-0`:  false,
+		`[$-409]mmmm d, yy`: true,
+	}
+	for code, want := range tests {
+		if got := isDateFormatCode(code); got != want {
+			t.Errorf("isDateFormatCode(%q) = %v, want %v", code, got, want)
+		}
+	}
+}